@@ -0,0 +1,288 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	pioutil "github.com/amazingchow/photon-dance-snap/ioutil"
+)
+
+// Backend is the storage the snap files are persisted in. Names passed to a
+// Backend are bare file names (e.g. "0000000000000001-0000000000000001.snap"),
+// relative to whatever root the Backend was created for.
+//
+// Open, Stat and Remove must return an error satisfying os.IsNotExist when the
+// named object does not exist.
+type Backend interface {
+	// Open opens the named object for reading.
+	Open(name string) (io.ReadCloser, error)
+	// Write stores data under name, replacing any existing object.
+	//
+	// When Write returns nil, the object must be durable, i.e. it survives a
+	// crash or power loss of the writer, and it must have become visible
+	// atomically: readers observe either no object (or the previous one) or
+	// the complete data, never a prefix of it. When Write returns an error,
+	// nothing is left under name but the previous object, if any: Write
+	// cleans up what it staged itself.
+	Write(name string, data []byte, perm os.FileMode) error
+	// List returns the names of all objects, in no particular order.
+	List() ([]string, error)
+	// Stat returns the size and modification time of the named object.
+	Stat(name string) (os.FileInfo, error)
+	// Remove removes the named object.
+	Remove(name string) error
+	// Rename renames oldname to newname, replacing newname if it exists.
+	Rename(oldname, newname string) error
+}
+
+// chmodder is implemented by backends whose objects carry file permissions.
+type chmodder interface {
+	Chmod(name string, perm os.FileMode) error
+}
+
+// chtimer is implemented by backends whose objects carry a modification time
+// that can be changed, see Touch.
+type chtimer interface {
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// dirSyncer is implemented by backends that need an explicit flush for
+// newly written objects to become durable, such as a directory fsync.
+type dirSyncer interface {
+	SyncDir() error
+}
+
+// dirCreator is implemented by backends that need their root to be
+// created before objects can be written to it.
+type dirCreator interface {
+	MkdirAll(perm os.FileMode) error
+}
+
+// unsyncedWriter is implemented by backends that can write without flushing
+// the data to stable storage, see WithSync.
+type unsyncedWriter interface {
+	WriteUnsynced(name string, data []byte, perm os.FileMode) error
+}
+
+// batchWriter is implemented by backends that can stage objects without
+// flushing them, and then make a batch of staged objects durable and visible
+// at once, see WithSyncBatch.
+type batchWriter interface {
+	// WriteStaged stores data under name, without flushing it nor making it
+	// visible under name yet.
+	WriteStaged(name string, data []byte, perm os.FileMode) error
+	// CommitStaged makes the objects staged under names durable, then
+	// visible under their name.
+	CommitStaged(names []string) error
+}
+
+// vectorWriter is implemented by backends that can write an object from
+// several buffers without concatenating them first, which is otherwise needed
+// to Write it, e.g. to avoid copying the data of large snapshots.
+type vectorWriter interface {
+	// WriteBuffers is like Write, with data split in bufs.
+	WriteBuffers(name string, bufs [][]byte, perm os.FileMode) error
+}
+
+// spaceChecker is implemented by backends that can tell how many bytes are
+// left for new objects, see WithSpaceCheck.
+type spaceChecker interface {
+	AvailableSpace() (uint64, error)
+}
+
+// tmpSuffix is appended to the name of a snap file while it is being written.
+const tmpSuffix = ".tmp"
+
+// FileBackend is a Backend storing snap files in a local directory. The
+// directory may be a symlink: every operation, the directory fsync and the
+// rename of written files included, resolves it to its target, so that written
+// files are renamed within the target volume.
+type FileBackend struct {
+	dir string
+	// tempDir holds the files being written, defaults to dir.
+	tempDir string
+}
+
+// NewFileBackend returns a FileBackend storing snap files in dir. Files being
+// written are kept in dir as well.
+func NewFileBackend(dir string) *FileBackend {
+	return &FileBackend{dir: dir, tempDir: dir}
+}
+
+// NewFileBackendWithTempDir is like NewFileBackend, but keeps the files being
+// written in tempDir. It fails if tempDir is not on the same device as dir,
+// since renaming the written files into dir would not be atomic then. Unlike
+// those in dir, files orphaned in tempDir by a crash are not cleaned up.
+func NewFileBackendWithTempDir(dir, tempDir string) (*FileBackend, error) {
+	same, err := fileutil.SameDevice(dir, tempDir)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, fmt.Errorf("snap: temporary directory %s is not on the same device as %s", tempDir, dir)
+	}
+	return &FileBackend{dir: dir, tempDir: tempDir}, nil
+}
+
+func (fb *FileBackend) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(fb.dir, name))
+}
+
+// Write writes and fsyncs the data to a "<name>.tmp" file in the temporary
+// directory first, then renames it to name, so that a crash in the middle of
+// the write never leaves a truncated file under name. The directory entry
+// becomes durable with SyncDir.
+func (fb *FileBackend) Write(name string, data []byte, perm os.FileMode) error {
+	fpath := filepath.Join(fb.dir, name)
+	tmpPath := filepath.Join(fb.tempDir, name+tmpSuffix)
+	if err := pioutil.WriteAndSyncFile(tmpPath, data, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fpath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// WriteBuffers is like Write, but writes the data from several buffers, one
+// after the other. Backends embedding a FileBackend to override Write must
+// override WriteBuffers as well.
+func (fb *FileBackend) WriteBuffers(name string, bufs [][]byte, perm os.FileMode) error {
+	fpath := filepath.Join(fb.dir, name)
+	tmpPath := filepath.Join(fb.tempDir, name+tmpSuffix)
+	if err := pioutil.WriteAndSyncBuffers(tmpPath, bufs, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fpath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// WriteUnsynced is like Write, but skips the fsync: the file still becomes
+// visible atomically, but may be lost or truncated by a crash.
+func (fb *FileBackend) WriteUnsynced(name string, data []byte, perm os.FileMode) error {
+	fpath := filepath.Join(fb.dir, name)
+	tmpPath := filepath.Join(fb.tempDir, name+tmpSuffix)
+	if err := ioutil.WriteFile(tmpPath, data, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, fpath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// WriteStaged writes the data to a "<name>.tmp" file in the temporary
+// directory, without fsyncing it, for CommitStaged to rename it to name.
+func (fb *FileBackend) WriteStaged(name string, data []byte, perm os.FileMode) error {
+	tmpPath := filepath.Join(fb.tempDir, name+tmpSuffix)
+	if err := ioutil.WriteFile(tmpPath, data, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// CommitStaged fsyncs the files staged under names, renames them to their
+// name once they are all durable, and fsyncs the directory once.
+func (fb *FileBackend) CommitStaged(names []string) error {
+	for _, name := range names {
+		f, err := os.Open(filepath.Join(fb.tempDir, name+tmpSuffix))
+		if err != nil {
+			return err
+		}
+		err = fileutil.Fsync(f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := os.Rename(filepath.Join(fb.tempDir, name+tmpSuffix), filepath.Join(fb.dir, name)); err != nil {
+			return err
+		}
+	}
+	return fb.SyncDir()
+}
+
+// AvailableSpace returns the number of bytes available in the temporary
+// directory, where the files are written first.
+func (fb *FileBackend) AvailableSpace() (uint64, error) {
+	return fileutil.AvailableSpace(fb.tempDir)
+}
+
+func (fb *FileBackend) Chmod(name string, perm os.FileMode) error {
+	return os.Chmod(filepath.Join(fb.dir, name), perm)
+}
+
+func (fb *FileBackend) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(filepath.Join(fb.dir, name), atime, mtime)
+}
+
+// MkdirAll creates the directory, along with any missing parents.
+func (fb *FileBackend) MkdirAll(perm os.FileMode) error {
+	return os.MkdirAll(fb.dir, perm)
+}
+
+// SyncDir fsyncs the directory, so that the entries of newly written
+// files survive a crash.
+func (fb *FileBackend) SyncDir() error {
+	dir, err := os.Open(fb.dir)
+	if err != nil {
+		return err
+	}
+	err = fileutil.Fsync(dir)
+	if cerr := dir.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (fb *FileBackend) List() ([]string, error) {
+	dir, err := os.Open(fb.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	return dir.Readdirnames(-1)
+}
+
+func (fb *FileBackend) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(fb.dir, name))
+}
+
+func (fb *FileBackend) Remove(name string) error {
+	return os.Remove(filepath.Join(fb.dir, name))
+}
+
+func (fb *FileBackend) Rename(oldname, newname string) error {
+	return os.Rename(filepath.Join(fb.dir, oldname), filepath.Join(fb.dir, newname))
+}
@@ -0,0 +1,445 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+)
+
+// recordingBackend is a FileBackend that records the names it was asked to write.
+type recordingBackend struct {
+	*FileBackend
+	written []string
+}
+
+func (rb *recordingBackend) Write(name string, data []byte, perm os.FileMode) error {
+	rb.written = append(rb.written, name)
+	return rb.FileBackend.Write(name, data, perm)
+}
+
+func (rb *recordingBackend) WriteBuffers(name string, bufs [][]byte, perm os.FileMode) error {
+	rb.written = append(rb.written, name)
+	return rb.FileBackend.WriteBuffers(name, bufs, perm)
+}
+
+// blockingBackend is a MemBackend whose writes hang until release is closed,
+// and are reported on written once done.
+type blockingBackend struct {
+	*MemBackend
+	release chan struct{}
+	written chan string
+}
+
+func (bb *blockingBackend) Write(name string, data []byte, perm os.FileMode) error {
+	<-bb.release
+	err := bb.MemBackend.Write(name, data, perm)
+	bb.written <- name
+	return err
+}
+
+// stallFirstBackend is a MemBackend whose first write hangs until release is
+// closed, and which reports the removed files on removed.
+type stallFirstBackend struct {
+	*MemBackend
+	release chan struct{}
+	removed chan string
+	stalled int32
+}
+
+func (sb *stallFirstBackend) Write(name string, data []byte, perm os.FileMode) error {
+	if atomic.CompareAndSwapInt32(&sb.stalled, 0, 1) {
+		<-sb.release
+	}
+	return sb.MemBackend.Write(name, data, perm)
+}
+
+func (sb *stallFirstBackend) Remove(name string) error {
+	err := sb.MemBackend.Remove(name)
+	sb.removed <- name
+	return err
+}
+
+// flakyBackend is a MemBackend whose first writes, as many as failures, fail
+// with err, leaving nothing behind as Backend.Write does.
+type flakyBackend struct {
+	*MemBackend
+	failures int
+	err      error
+	writes   int
+	removed  int
+}
+
+func (fb *flakyBackend) Write(name string, data []byte, perm os.FileMode) error {
+	fb.writes++
+	if fb.failures > 0 {
+		fb.failures--
+		return fb.err
+	}
+	return fb.MemBackend.Write(name, data, perm)
+}
+
+func (fb *flakyBackend) Remove(name string) error {
+	fb.removed++
+	return fb.MemBackend.Remove(name)
+}
+
+// fullBackend is a MemBackend reporting only avail bytes of available space.
+type fullBackend struct {
+	*MemBackend
+	avail uint64
+}
+
+func (fb *fullBackend) AvailableSpace() (uint64, error) {
+	return fb.avail, nil
+}
+
+// renameFailBackend is a MemBackend whose renames fail with err.
+type renameFailBackend struct {
+	*MemBackend
+	err error
+}
+
+func (rb *renameFailBackend) Rename(oldname, newname string) error {
+	return rb.err
+}
+
+func TestWithBackend(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the snapshotter directory is only used for logging when a backend is given
+	rb := &recordingBackend{FileBackend: NewFileBackend(dir)}
+	ss := NewSnapshotter("", WithBackend(rb))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{"0000000000000001-0000000000000001.snap"}; !reflect.DeepEqual(rb.written, w) {
+		t.Errorf("written = %v, want %v", rb.written, w)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func TestNewFileBackendWithTempDir(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tempDir := filepath.Join(os.TempDir(), "snapshot-tmp")
+	if err = os.Mkdir(tempDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fb, err := NewFileBackendWithTempDir(dir, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := NewSnapshotter(dir, WithBackend(fb))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// procfs is never on the same device as the snapshot directory
+	if _, err = os.Stat("/proc"); err != nil {
+		t.Skip("no /proc to test a temporary directory on another device")
+	}
+	if _, err = NewFileBackendWithTempDir(dir, "/proc"); err == nil {
+		t.Errorf("err = nil, want an error for a temporary directory on another device")
+	}
+}
+
+func TestWithWriteTimeout(t *testing.T) {
+	bb := &blockingBackend{MemBackend: NewMemBackend(), release: make(chan struct{}), written: make(chan string, 2)}
+	ss := NewSnapshotter("", WithBackend(bb), WithWriteTimeout(10*time.Millisecond))
+	if err := ss.SaveSnap(testSnap); err != ErrWriteTimeout {
+		t.Errorf("err = %v, want %v", err, ErrWriteTimeout)
+	}
+
+	// the hung write eventually completes, and its file is removed
+	close(bb.release)
+	name := <-bb.written
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ss.mu.RLock()
+		_, err := bb.Stat(name)
+		ss.mu.RUnlock()
+		if os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("err = %v, want the abandoned snap file to be removed", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithSpaceCheck(t *testing.T) {
+	fb := &fullBackend{MemBackend: NewMemBackend(), avail: 1 << 10}
+	ss := NewSnapshotter("", WithBackend(fb), WithSpaceCheck(1<<10))
+	if err := ss.SaveSnap(testSnap); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("err = %v, want %v", err, ErrInsufficientSpace)
+	}
+	if names, _ := fb.List(); len(names) != 0 {
+		t.Errorf("names = %v, want none", names)
+	}
+
+	ss = NewSnapshotter("", WithBackend(fb), WithSpaceCheck(0))
+	if err := ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	// the check is done for the snapshot directory of a FileBackend too
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss = NewSnapshotter(dir, WithSpaceCheck(1<<62))
+	if err := ss.SaveSnap(testSnap); !errors.Is(err, ErrInsufficientSpace) {
+		t.Errorf("err = %v, want %v", err, ErrInsufficientSpace)
+	}
+}
+
+func TestSymlinkedSnapshotDir(t *testing.T) {
+	root := filepath.Join(os.TempDir(), "snapshot")
+	if err := os.Mkdir(root, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	target := filepath.Join(root, "volume")
+	if err := os.Mkdir(target, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "snap")
+	if err := os.Symlink(target, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	fb, err := NewFileBackendWithTempDir(dir, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ss := range []*Snapshotter{NewSnapshotter(dir), NewSnapshotter(dir, WithBackend(fb))} {
+		if err = ss.SaveSnap(testSnap); err != nil {
+			t.Fatal(err)
+		}
+		g, err := ss.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(g, testSnap) {
+			t.Errorf("snap = %#v, want %#v", g, testSnap)
+		}
+	}
+	names, err := fileutil.ReadDir(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 1)}; !reflect.DeepEqual(names, w) {
+		t.Errorf("names = %v, want %v", names, w)
+	}
+	// the symlink itself was left alone
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("mode = %v, want a symlink", fi.Mode())
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	tests := []struct {
+		opts    []SnapshotterOption
+		err     error
+		wwrites int
+		werr    error
+	}{
+		// failures are not retried by default
+		{nil, syscall.EIO, 1, syscall.EIO},
+		{[]SnapshotterOption{WithRetry(3, time.Millisecond)}, syscall.EIO, 3, nil},
+		{[]SnapshotterOption{WithRetry(2, time.Millisecond)}, syscall.EIO, 2, syscall.EIO},
+		{[]SnapshotterOption{WithRetry(3, time.Millisecond)}, syscall.ENOSPC, 1, syscall.ENOSPC},
+		{[]SnapshotterOption{WithRetry(3, time.Millisecond), WithRetryIf(func(error) bool { return false })}, syscall.EIO, 1, syscall.EIO},
+	}
+	for i, tt := range tests {
+		fb := &flakyBackend{MemBackend: NewMemBackend(), failures: 2, err: tt.err}
+		ss := NewSnapshotter("", append(tt.opts, WithBackend(fb))...)
+		if err := ss.SaveSnap(testSnap); !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+		if fb.writes != tt.wwrites {
+			t.Errorf("#%d: writes = %d, want %d", i, fb.writes, tt.wwrites)
+		}
+		if fb.removed != 0 {
+			t.Errorf("#%d: removed = %d, want 0", i, fb.removed)
+		}
+		if tt.werr != nil {
+			continue
+		}
+		g, err := ss.Load()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if !proto.Equal(g, testSnap) {
+			t.Errorf("#%d: snap = %#v, want %#v", i, g, testSnap)
+		}
+	}
+}
+
+func TestBrokenRenameError(t *testing.T) {
+	be := &renameFailBackend{MemBackend: NewMemBackend(), err: syscall.EACCES}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if err := be.Write(name, []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewSnapshotter("snapshot", WithBackend(be)).Load()
+	if !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	var berr *BrokenRenameError
+	if !errors.As(err, &berr) {
+		t.Fatalf("err = %v, want a *BrokenRenameError", err)
+	}
+	if berr.RenameErr != syscall.EACCES || !errors.Is(err, syscall.EACCES) {
+		t.Errorf("rename err = %v, want %v", berr.RenameErr, syscall.EACCES)
+	}
+	var cerr *CorruptSnapshotError
+	if !errors.As(errors.Unwrap(berr), &cerr) {
+		t.Errorf("unwrapped err = %v, want a *CorruptSnapshotError", errors.Unwrap(berr))
+	}
+}
+
+// readFailBackend is a MemBackend whose reads fail with err.
+type readFailBackend struct {
+	*MemBackend
+	err error
+}
+
+func (rb *readFailBackend) Open(name string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(errReader{rb.err}), nil
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func TestReadErrorNotRenamed(t *testing.T) {
+	be := &readFailBackend{MemBackend: NewMemBackend(), err: syscall.EINTR}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if err := be.Write(name, []byte("some data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewSnapshotter("snapshot", WithBackend(be)).Load()
+	if !errors.Is(err, ErrNoSnapshot) || !errors.Is(err, syscall.EINTR) {
+		t.Errorf("err = %v, want %v wrapping %v", err, ErrNoSnapshot, syscall.EINTR)
+	}
+	names, err := be.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{name}; !reflect.DeepEqual(names, w) {
+		t.Errorf("names = %v, want %v", names, w)
+	}
+}
+
+func TestAbandonedWriteKeepsRetry(t *testing.T) {
+	sb := &stallFirstBackend{MemBackend: NewMemBackend(), release: make(chan struct{}), removed: make(chan string, 1)}
+	ss := NewSnapshotter("", WithBackend(sb), WithWriteTimeout(10*time.Millisecond))
+	if err := ss.SaveSnap(testSnap); err != ErrWriteTimeout {
+		t.Errorf("err = %v, want %v", err, ErrWriteTimeout)
+	}
+	if err := ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	// the write given up on finishes after the retry, and removes only its own
+	// file
+	close(sb.release)
+	<-sb.removed
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func TestFailedWriteKeepsPrevious(t *testing.T) {
+	fb := &flakyBackend{MemBackend: NewMemBackend(), err: syscall.EIO}
+	ss := NewSnapshotter("", WithBackend(fb))
+	if err := ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed re-save of the same term and index leaves the previous snap
+	// file in place, also when every attempt of WithRetry fails
+	for _, rs := range []*Snapshotter{ss, NewSnapshotter("", WithBackend(fb), WithRetry(2, time.Millisecond))} {
+		fb.failures = rs.retryAttempts
+		if err := rs.SaveSnap(testSnap); !errors.Is(err, syscall.EIO) {
+			t.Errorf("err = %v, want %v", err, syscall.EIO)
+		}
+		g, err := rs.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(g, testSnap) {
+			t.Errorf("snap = %#v, want %#v", g, testSnap)
+		}
+	}
+}
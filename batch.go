@@ -0,0 +1,124 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// batched reports whether saves are staged to be flushed as a batch,
+// see WithSyncBatch.
+func (s *Snapshotter) batched() bool {
+	_, ok := s.backend.(batchWriter)
+	return ok && s.sync && (s.batchInterval > 0 || s.batchSize > 0)
+}
+
+// writeBatched stages the content bufs of the snap file fname, whose save
+// started at start, and flushes the batch once it is full.
+func (s *Snapshotter) writeBatched(fname string, bufs [][]byte, start time.Time) error {
+	b := concatBuffers(bufs)
+	spath := filepath.Join(s.dir, fname)
+	perm := s.fileMode
+	if perm == 0 {
+		perm = defaultFileMode
+	}
+	if err := s.backend.(batchWriter).WriteStaged(fname, b, perm); err != nil {
+		s.lg.Warn().Err(err).Str("path", spath).Msg("failed to stage a snap file")
+		return err
+	}
+	if s.pending == nil {
+		s.pending = make(map[string]bool)
+	}
+	s.pending[fname] = true
+
+	if s.mirror != nil {
+		s.writeMirror(fname, b)
+	}
+	snapSaveSec.Observe(s.clock.Now().Sub(start).Seconds())
+
+	if s.batchSize > 0 && len(s.pending) >= s.batchSize {
+		if err := s.flush(); err != nil {
+			return err
+		}
+	} else if s.batchInterval > 0 && s.batchTimer == nil {
+		s.batchTimer = time.AfterFunc(s.batchInterval, func() {
+			if err := s.Flush(); err != nil {
+				s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to flush staged snap files")
+			}
+		})
+	}
+	return nil
+}
+
+// latestPending returns the term and index of the newest snap file staged by
+// WithSyncBatch and not flushed yet, if any.
+func (s *Snapshotter) latestPending() (term, index uint64, ok bool) {
+	for name := range s.pending {
+		t, i, err := s.parseSnapName(name)
+		if err == nil && (!ok || t > term || (t == term && i > index)) {
+			term, index, ok = t, i, true
+		}
+	}
+	return term, index, ok
+}
+
+// Flush makes the snap files staged by WithSyncBatch durable and visible right
+// away, e.g. before shutting down. It is a no-op when nothing is staged.
+func (s *Snapshotter) Flush() error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	return s.flush()
+}
+
+// flush is Flush without locking. The staged files are forgotten even when the
+// flush fails, those left behind are then cleaned up as orphaned files.
+func (s *Snapshotter) flush() error {
+	if s.batchTimer != nil {
+		s.batchTimer.Stop()
+		s.batchTimer = nil
+	}
+	if len(s.pending) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(s.pending))
+	for name := range s.pending {
+		names = append(names, name)
+	}
+	s.sortSnapNames(names)
+	s.pending = nil
+
+	fsyncStart := s.clock.Now()
+	if err := s.backend.(batchWriter).CommitStaged(names); err != nil {
+		s.lg.Warn().Err(err).Str("path", s.dir).Int("count", len(names)).Msg("failed to flush staged snap files")
+		return err
+	}
+	snapFsyncSec.Observe(s.clock.Now().Sub(fsyncStart).Seconds())
+
+	if s.fileMode != 0 {
+		if cm, ok := s.backend.(chmodder); ok {
+			for _, name := range names {
+				if err := cm.Chmod(name, s.fileMode); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if s.maxBytes > 0 {
+		s.evict(names[0])
+	}
+	return nil
+}
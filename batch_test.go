@@ -0,0 +1,169 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestWithSyncBatch(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithSyncBatch(time.Hour, 2))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	first := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if fileutil.Exist(filepath.Join(dir, first)) {
+		t.Errorf("expected %s to be staged until the batch is flushed", first)
+	}
+	if _, err = ss.Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if !fileutil.Exist(filepath.Join(dir, first+".tmp")) {
+		t.Errorf("expected the staged file of %s not to be cleaned up", first)
+	}
+
+	newSnap := proto.Clone(testSnap).(*snappb.Snapshot)
+	newSnap.Metadata.Index = 2
+	if err = ss.save(newSnap); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{first, fmt.Sprintf("%016x-%016x.snap", 1, 2)} {
+		if !fileutil.Exist(filepath.Join(dir, name)) || fileutil.Exist(filepath.Join(dir, name+".tmp")) {
+			t.Errorf("expected %s to be flushed once the batch is full", name)
+		}
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, newSnap) {
+		t.Errorf("snap = %#v, want %#v", g, newSnap)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithSyncBatch(time.Hour, 0))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if err = ss.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	if err = ss.Flush(); err != nil {
+		t.Errorf("err = %v, want nil flushing an empty batch", err)
+	}
+}
+
+func TestWithSyncBatchInterval(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithSyncBatch(10*time.Millisecond, 0))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	for deadline := time.Now().Add(5 * time.Second); !fileutil.Exist(fpath); {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be flushed once the interval passed", fpath)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSyncBatchChecksStaged(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithSyncBatch(time.Hour, 0), WithOverwrite(false))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.save(testSnap); !errors.Is(err, ErrSnapshotExists) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotExists)
+	}
+
+	ss = NewSnapshotter(dir, WithSyncBatch(time.Hour, 0), WithMonotonicIndex(true))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	older := proto.Clone(testSnap).(*snappb.Snapshot)
+	older.Metadata.Index = 2
+	if err = ss.save(older); err != nil {
+		t.Fatal(err)
+	}
+	older.Metadata.Term, older.Metadata.Index = 2, 1
+	if err = ss.save(older); !errors.Is(err, ErrNonMonotonicIndex) {
+		t.Errorf("err = %v, want %v", err, ErrNonMonotonicIndex)
+	}
+
+	// a staged snap file is rewritten rather than the one on disk
+	ss = NewSnapshotter(dir, WithSyncBatch(time.Hour, 0), WithCompression(ZstdLevel(3)))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.Rewrite(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	_, saved, err := ss.readSnapWithEnvelope(fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Codec != snappb.Codec_ZSTD {
+		t.Errorf("codec = %v, want %v", saved.Codec, snappb.Codec_ZSTD)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+
+	"github.com/cespare/xxhash/v2"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// computeChecksum computes the checksum of the concatenation of bufs with the
+// given algorithm. crc32 checksums, computed with table, are returned in crc to
+// stay compatible with snap files written before the algorithm became
+// configurable, all the other algorithms return their digest in sum.
+func computeChecksum(algo snappb.ChecksumAlgo, table *crc32.Table, bufs ...[]byte) (crc uint32, sum []byte, err error) {
+	var h hash.Hash
+	switch algo {
+	case snappb.ChecksumAlgo_CRC32C:
+		for _, b := range bufs {
+			crc = crc32.Update(crc, table, b)
+		}
+		return crc, nil, nil
+	case snappb.ChecksumAlgo_XXHASH64:
+		h = xxhash.New()
+	case snappb.ChecksumAlgo_SHA256:
+		h = sha256.New()
+	default:
+		return 0, nil, ErrUnsupportedChecksum
+	}
+	for _, b := range bufs {
+		h.Write(b)
+	}
+	return 0, h.Sum(nil), nil
+}
+
+// crcPoly returns the polynomial, in reversed notation, table was made for.
+func crcPoly(table *crc32.Table) uint32 {
+	// for a reversed table, the entry of the top bit is the polynomial itself
+	return table[0x80]
+}
+
+// writeCRCTable returns the table the crc of new snap files is computed with,
+// and the polynomial to record for it in their envelope, zero for the default.
+func (s *Snapshotter) writeCRCTable() (*crc32.Table, uint32) {
+	if s.crcTable == nil {
+		return crcTable, 0
+	}
+	return s.crcTable, crcPoly(s.crcTable)
+}
+
+// readCRCTable returns the table the crc of the envelope saved was computed
+// with, as recorded in it.
+func (s *Snapshotter) readCRCTable(saved *snappb.SavedSnapshot) *crc32.Table {
+	switch {
+	case saved.CrcPoly == 0:
+		return crcTable
+	case s.crcTable != nil && crcPoly(s.crcTable) == saved.CrcPoly:
+		return s.crcTable
+	default:
+		return crc32.MakeTable(saved.CrcPoly)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// stepClock advances by step every time it is read.
+type stepClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+func histogramSum(t *testing.T, h prometheus.Histogram) float64 {
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleSum()
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestWithClock(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithClock(&stepClock{step: time.Second}))
+	before := histogramSum(t, snapFsyncSec)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	// the clock is read once before and once after the write
+	if d := histogramSum(t, snapFsyncSec) - before; math.Abs(d-1) > 1e-9 {
+		t.Errorf("observed fsync duration = %vs, want 1s", d)
+	}
+}
+
+func TestSaveTotal(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithClock(&stepClock{step: time.Second}), WithOverwrite(false))
+	success := counterValue(t, snapSaveTotal.WithLabelValues("success"))
+	failure := counterValue(t, snapSaveTotal.WithLabelValues("error"))
+	before := histogramSum(t, snapSaveFailureSec)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.save(testSnap); err == nil {
+		t.Fatal("err = nil, want an error for an existing snapshot")
+	}
+
+	if d := counterValue(t, snapSaveTotal.WithLabelValues("success")) - success; d != 1 {
+		t.Errorf("successful saves = %v, want 1", d)
+	}
+	if d := counterValue(t, snapSaveTotal.WithLabelValues("error")) - failure; d != 1 {
+		t.Errorf("failed saves = %v, want 1", d)
+	}
+	// the failed save gives up before reading the clock in between
+	if d := histogramSum(t, snapSaveFailureSec) - before; math.Abs(d-1) > 1e-9 {
+		t.Errorf("observed failure duration = %vs, want 1s", d)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// Compression describes how the snapshot payload is compressed before
+// it is persisted. The zero value disables compression.
+type Compression struct {
+	codec snappb.Codec
+	level int
+}
+
+// ZstdLevel compresses the snapshot payload with zstd at the given level.
+// Levels follow the zstd command line convention (1 fastest, 22 smallest).
+func ZstdLevel(level int) Compression {
+	return Compression{codec: snappb.Codec_ZSTD, level: level}
+}
+
+// zstdEncoders caches an encoder per level, created on first use and kept for
+// the life of the process. EncodeAll is safe for concurrent use.
+var zstdEncoders = struct {
+	sync.Mutex
+	m map[zstd.EncoderLevel]*zstd.Encoder
+}{m: make(map[zstd.EncoderLevel]*zstd.Encoder)}
+
+// zstdEncoder returns the cached encoder of the zstd level.
+func zstdEncoder(level int) (*zstd.Encoder, error) {
+	l := zstd.EncoderLevelFromZstd(level)
+	zstdEncoders.Lock()
+	defer zstdEncoders.Unlock()
+	if enc, ok := zstdEncoders.m[l]; ok {
+		return enc, nil
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(l))
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders.m[l] = enc
+	return enc, nil
+}
+
+// compress compresses b with c, appending the result to dst. b itself is
+// returned when compression is disabled.
+func compress(c Compression, b, dst []byte) ([]byte, error) {
+	switch c.codec {
+	case snappb.Codec_NONE:
+		return b, nil
+	case snappb.Codec_ZSTD:
+		enc, err := zstdEncoder(c.level)
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(b, dst), nil
+	default:
+		return nil, ErrUnsupportedCodec
+	}
+}
+
+func decompress(codec snappb.Codec, b []byte) ([]byte, error) {
+	switch codec {
+	case snappb.Codec_NONE:
+		return b, nil
+	case snappb.Codec_ZSTD:
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer dec.Close()
+		return dec.DecodeAll(b, nil)
+	default:
+		return nil, ErrUnsupportedCodec
+	}
+}
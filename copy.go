@@ -0,0 +1,109 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CopyTo copies the newest valid snap file to dst byte for byte, without
+// decoding and re-encoding the snapshot, and returns its file name. The file
+// keeps its name in dst and is verified once written there; a .snap.gz file
+// is gunzipped and copied as a .snap file.
+//
+// If dst already holds a file under that name, ErrSnapshotExists is returned
+// along with the name, unless force is set, in which case it is overwritten.
+func (s *Snapshotter) CopyTo(dst *Snapshotter, force bool) (string, error) {
+	if dst == s {
+		return "", errors.New("snap: cannot copy a snapshot onto itself")
+	}
+	name, b, err := s.newestValidFile()
+	if err != nil {
+		return "", err
+	}
+
+	// the source lock is released by now, so that copies in both directions
+	// between two snapshotters cannot deadlock.
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	if !force {
+		if _, err = dst.backend.Stat(name); err == nil {
+			return name, ErrSnapshotExists
+		}
+	}
+	// the copy is written and verified under a name of its own, so that a
+	// failure leaves the file it would replace in place.
+	wname := dst.attemptName(name)
+	if err = dst.writeFile(wname, b); err != nil {
+		return "", err
+	}
+	if _, err = dst.readSnap(wname); err != nil {
+		dst.lg.Warn().Err(err).Str("path", filepath.Join(dst.dir, wname)).Msg("failed to verify copied snap file")
+		dst.removeCopy(wname)
+		return "", err
+	}
+	if err = dst.backend.Rename(wname, name); err != nil {
+		dst.removeCopy(wname)
+		return "", err
+	}
+	if ds, ok := dst.backend.(dirSyncer); ok {
+		if err = ds.SyncDir(); err != nil {
+			dst.lg.Warn().Err(err).Str("path", dst.dir).Msg("failed to fsync the snapshot directory")
+		}
+	}
+	return name, nil
+}
+
+// removeCopy removes the file wname a copy was written to.
+func (s *Snapshotter) removeCopy(wname string) {
+	if err := s.backend.Remove(wname); err != nil && !os.IsNotExist(err) {
+		s.lg.Warn().Err(err).Str("path", filepath.Join(s.dir, wname)).Msg("failed to remove a copied snap file")
+	}
+}
+
+// newestValidFile returns the name and raw content of the newest snap file
+// that passes verification. Corrupt files are skipped but left in place.
+func (s *Snapshotter) newestValidFile() (string, []byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.snapnames()
+	if err != nil {
+		return "", nil, err
+	}
+	var corruptErr error
+	for _, name := range names {
+		b, err := s.readFile(name)
+		if err != nil {
+			s.lg.Warn().Err(err).Str("path", filepath.Join(s.dir, name)).Msg("failed to read a snap file")
+			continue
+		}
+		if _, _, err = s.decodeSnap(filepath.Join(s.dir, name), b); err != nil {
+			if corruptErr == nil {
+				corruptErr = &CorruptSnapshotError{Path: filepath.Join(s.dir, name), Err: err}
+			}
+			continue
+		}
+		return strings.TrimSuffix(name, gzSuffix), b, nil
+	}
+	if corruptErr != nil {
+		return "", nil, &noSnapshotError{err: corruptErr}
+	}
+	return "", nil, ErrNoSnapshot
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+	"github.com/golang/protobuf/proto" // nolint
+)
+
+func TestCopyTo(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dstDir := filepath.Join(os.TempDir(), "snapshot-copy")
+	if err = os.Mkdir(dstDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dstDir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	// the newest snap file is corrupt and must be skipped
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2)), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewSnapshotter(dstDir)
+	name, err := ss.CopyTo(dst, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := fmt.Sprintf("%016x-%016x.snap", 1, 1); name != w {
+		t.Errorf("name = %s, want %s", name, w)
+	}
+	g, err := dst.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	if _, err = ss.CopyTo(dst, false); err != ErrSnapshotExists {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotExists)
+	}
+	if _, err = ss.CopyTo(dst, true); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+func TestCopyToFailureKeepsFile(t *testing.T) {
+	ss := NewSnapshotter("", WithBackend(NewMemBackend()))
+	if err := ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	older := &snappb.Snapshot{
+		Data:     []byte("older snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1},
+	}
+
+	errWrite := errors.New("write failed")
+	for _, tt := range []struct {
+		name string
+		be   func(*MemBackend) Backend
+	}{
+		{"write", func(mb *MemBackend) Backend { return &flakyBackend{MemBackend: mb, failures: 1, err: errWrite} }},
+		{"rename", func(mb *MemBackend) Backend { return &renameFailBackend{MemBackend: mb, err: errWrite} }},
+	} {
+		mb := NewMemBackend()
+		if err := NewSnapshotter("", WithBackend(mb)).save(older); err != nil {
+			t.Fatal(err)
+		}
+		dst := NewSnapshotter("", WithBackend(tt.be(mb)))
+		if _, err := ss.CopyTo(dst, true); !errors.Is(err, errWrite) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, errWrite)
+		}
+		g, err := dst.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(g, older) {
+			t.Errorf("%s: snap = %#v, want %#v", tt.name, g, older)
+		}
+		if names, _ := mb.List(); len(names) != 1 {
+			t.Errorf("%s: files = %v, want only the previous snap file", tt.name, names)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// SaveDelta saves snapshot as a delta against base, storing only how its data
+// differs from the data of base, which must be saved already and be older than
+// snapshot. The snapshot is reconstructed from base transparently when loaded,
+// so base must be kept as long as snapshot is: Prune, PruneOlderThan and
+// WithMaxBytes keep the bases of the deltas they keep, while loading a delta
+// whose base is gone otherwise fails with an error wrapping
+// ErrDeltaBaseNotFound. Deltas may be based on deltas in turn.
+func (s *Snapshotter) SaveDelta(base, snapshot *snappb.Snapshot) error {
+	if snapshot.Metadata == nil || snapshot.Metadata.Index == 0 {
+		return nil
+	}
+	if base.GetMetadata().GetIndex() == 0 || base.Metadata.Index >= snapshot.Metadata.Index {
+		return errors.New("snap: the base of a delta must be an older snapshot")
+	}
+	delta := &snappb.Snapshot{
+		Data:     encodeDelta(base.Data, snapshot.Data),
+		Metadata: snapshot.Metadata,
+	}
+	_, err := s.saveSnap(context.Background(), delta, base.Metadata)
+	return err
+}
+
+// applyDelta replaces the data of the delta snapshot snap, read from the
+// snap file snapname, with the data reconstructed from its base.
+func (s *Snapshotter) applyDelta(snapname string, snap *snappb.Snapshot, saved *snappb.SavedSnapshot) error {
+	// bases are strictly older, which guarantees that a chain ends
+	if saved.BaseIndex >= snap.Metadata.GetIndex() {
+		s.lg.Warn().Str("path", snapname).Uint64("base-index", saved.BaseIndex).Msg("delta snapshot refers to a newer base")
+		return fmt.Errorf("snap: delta snapshot at index %d refers to base index %d", snap.Metadata.GetIndex(), saved.BaseIndex)
+	}
+	baseName := s.snapName(saved.BaseTerm, saved.BaseIndex)
+	base, err := s.readSnap(baseName)
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", snapname).Str("base-path", baseName).Msg("failed to load the base of a delta snapshot")
+		if os.IsNotExist(err) {
+			return &deltaBaseError{fmt.Errorf("%w: term %d, index %d", ErrDeltaBaseNotFound, saved.BaseTerm, saved.BaseIndex)}
+		}
+		return &deltaBaseError{fmt.Errorf("failed to load base snapshot %s: %w", baseName, err)}
+	}
+	data, err := patchDelta(base.Data, snap.Data)
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", snapname).Str("base-path", baseName).Msg("failed to apply delta snapshot")
+		return err
+	}
+	snap.Data = data
+	return nil
+}
+
+// deltaBases returns the names of the snap files that the deltas among names
+// are based on, directly or through other deltas, and that must be kept as
+// long as the deltas are. Files that fail to read or decode are skipped: they
+// cannot be loaded either way.
+func (s *Snapshotter) deltaBases(names []string) map[string]bool {
+	bases := make(map[string]bool)
+	queue := append([]string(nil), names...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if _, _, err := s.parseSnapName(name); err != nil {
+			continue
+		}
+		b, err := s.readFile(name)
+		if err != nil {
+			continue
+		}
+		saved, err := s.decodeEnvelope(filepath.Join(s.dir, name), b)
+		if err != nil || saved.BaseIndex == 0 {
+			continue
+		}
+		if base := s.snapName(saved.BaseTerm, saved.BaseIndex); !bases[base] {
+			bases[base] = true
+			queue = append(queue, base)
+		}
+	}
+	return bases
+}
+
+// A delta is the crc32 of the target data, followed by a sequence of
+// operations rebuilding the target: deltaCopy, with the offset and length of
+// bytes to copy from the base, and deltaInsert, with the length of the bytes
+// that follow. Numbers are encoded as uvarints.
+const (
+	deltaCopy   byte = 'c'
+	deltaInsert byte = 'i'
+
+	// deltaBlockSize is the size of the blocks of the base that are looked
+	// up in the target; shorter matches are inserted.
+	deltaBlockSize = 64
+	// deltaPrime is the multiplier of the rolling hash.
+	deltaPrime = 16777619
+)
+
+var errInvalidDelta = errors.New("snap: invalid delta")
+
+// encodeDelta returns a delta rebuilding target from base.
+func encodeDelta(base, target []byte) []byte {
+	delta := make([]byte, 4, 4+len(target)/8)
+	binary.BigEndian.PutUint32(delta, crc32.Checksum(target, crcTable))
+
+	blocks := make(map[uint32]int, len(base)/deltaBlockSize)
+	for off := len(base) - deltaBlockSize; off >= 0; off -= deltaBlockSize {
+		// walking backwards, the lowest offset of a repeated block wins
+		blocks[blockHash(base[off:off+deltaBlockSize])] = off
+	}
+	// pow removes the outgoing byte from the rolling hash
+	pow := uint32(1)
+	for i := 0; i < deltaBlockSize-1; i++ {
+		pow *= deltaPrime
+	}
+
+	literal := 0
+	var h uint32
+	hashed := false
+	for i := 0; i+deltaBlockSize <= len(target); {
+		if !hashed {
+			h, hashed = blockHash(target[i:i+deltaBlockSize]), true
+		}
+		if off, ok := blocks[h]; ok && bytes.Equal(base[off:off+deltaBlockSize], target[i:i+deltaBlockSize]) {
+			n := deltaBlockSize
+			for off+n < len(base) && i+n < len(target) && base[off+n] == target[i+n] {
+				n++
+			}
+			delta = appendInsert(delta, target[literal:i])
+			delta = append(delta, deltaCopy)
+			delta = appendUvarint(delta, uint64(off))
+			delta = appendUvarint(delta, uint64(n))
+			i += n
+			literal, hashed = i, false
+			continue
+		}
+		if i+deltaBlockSize < len(target) {
+			h = (h-uint32(target[i])*pow)*deltaPrime + uint32(target[i+deltaBlockSize])
+		}
+		i++
+	}
+	return appendInsert(delta, target[literal:])
+}
+
+func appendInsert(delta, b []byte) []byte {
+	if len(b) == 0 {
+		return delta
+	}
+	delta = append(delta, deltaInsert)
+	delta = appendUvarint(delta, uint64(len(b)))
+	return append(delta, b...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func blockHash(b []byte) uint32 {
+	var h uint32
+	for _, c := range b {
+		h = h*deltaPrime + uint32(c)
+	}
+	return h
+}
+
+// patchDelta rebuilds the target data of delta from base.
+func patchDelta(base, delta []byte) ([]byte, error) {
+	if len(delta) < 4 {
+		return nil, errInvalidDelta
+	}
+	crc := binary.BigEndian.Uint32(delta)
+	delta = delta[4:]
+	var target []byte
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch op {
+		case deltaCopy:
+			off, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, errInvalidDelta
+			}
+			delta = delta[n:]
+			length, n := binary.Uvarint(delta)
+			if n <= 0 || off > uint64(len(base)) || length > uint64(len(base))-off {
+				return nil, errInvalidDelta
+			}
+			delta = delta[n:]
+			target = append(target, base[off:off+length]...)
+		case deltaInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 || length > uint64(len(delta)-n) {
+				return nil, errInvalidDelta
+			}
+			delta = delta[n:]
+			target = append(target, delta[:length]...)
+			delta = delta[length:]
+		default:
+			return nil, errInvalidDelta
+		}
+	}
+	if crc32.Checksum(target, crcTable) != crc {
+		return nil, fmt.Errorf("%w: delta does not apply to its base", ErrCRCMismatch)
+	}
+	return target, nil
+}
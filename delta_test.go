@@ -0,0 +1,193 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestDeltaEncoding(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	base := make([]byte, 64*1024)
+	rnd.Read(base)
+
+	target := append([]byte(nil), base...)
+	// scattered updates, an insertion shifting the rest, and an append
+	for i := 0; i < 10; i++ {
+		target[rnd.Intn(len(target))] ^= 0xff
+	}
+	target = append(target[:1000], append([]byte("inserted"), target[1000:]...)...)
+	target = append(target, "appended"...)
+
+	tests := []struct {
+		base, target []byte
+	}{
+		{base, target},
+		{base, nil},
+		{nil, target},
+		{[]byte("short"), []byte("shorter")},
+	}
+	for i, tt := range tests {
+		delta := encodeDelta(tt.base, tt.target)
+		g, err := patchDelta(tt.base, delta)
+		if err != nil {
+			t.Fatalf("#%d: err = %v", i, err)
+		}
+		if !bytes.Equal(g, tt.target) {
+			t.Errorf("#%d: patched data differs from the target", i)
+		}
+	}
+	if delta := encodeDelta(base, target); len(delta) > len(target)/10 {
+		t.Errorf("len(delta) = %d, want it much smaller than %d", len(delta), len(target))
+	}
+	if _, err := patchDelta(target, encodeDelta(base, target)); !errors.Is(err, ErrCRCMismatch) {
+		t.Errorf("err = %v, want %v", err, ErrCRCMismatch)
+	}
+}
+
+func TestSaveDelta(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("some snapshot "), 1000)
+	base := &snappb.Snapshot{Data: data, Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1}}
+	next := &snappb.Snapshot{
+		Data:     append(append([]byte(nil), data...), "more"...),
+		Metadata: &snappb.SnapshotMetadata{Index: 2, Term: 1},
+	}
+	last := &snappb.Snapshot{
+		Data:     append(append([]byte(nil), next.Data...), "even more"...),
+		Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1},
+	}
+
+	ss := NewSnapshotter(dir, WithBrokenRename(false))
+	if err = ss.SaveDelta(base, next); !errors.Is(err, ErrDeltaBaseNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrDeltaBaseNotFound)
+	}
+	if err = ss.SaveSnap(base); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.SaveDelta(base, next); err != nil {
+		t.Fatal(err)
+	}
+	// a delta based on a delta
+	if err = ss.SaveDelta(next, last); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, last) {
+		t.Errorf("snap = %#v, want %#v", g.Metadata, last.Metadata)
+	}
+	if g, err = ss.LoadByIndex(2); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, next) {
+		t.Errorf("snap = %#v, want %#v", g.Metadata, next.Metadata)
+	}
+
+	// without their base, the deltas cannot be loaded
+	if err = os.Remove(filepath.Join(dir, "0000000000000001-0000000000000001.snap")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.readSnap("0000000000000001-0000000000000003.snap"); !errors.Is(err, ErrDeltaBaseNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrDeltaBaseNotFound)
+	}
+}
+
+func TestPruneKeepsDeltaBases(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bytes.Repeat([]byte("some snapshot "), 1000)
+	base := &snappb.Snapshot{Data: data, Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1}}
+	next := &snappb.Snapshot{
+		Data:     append(append([]byte(nil), data...), "more"...),
+		Metadata: &snappb.SnapshotMetadata{Index: 2, Term: 1},
+	}
+	last := &snappb.Snapshot{
+		Data:     append(append([]byte(nil), next.Data...), "even more"...),
+		Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1},
+	}
+
+	ss := NewSnapshotter(dir)
+	if err = ss.SaveSnap(base); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.SaveDelta(base, next); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.SaveDelta(next, last); err != nil {
+		t.Fatal(err)
+	}
+
+	// the kept delta needs the whole chain of its bases
+	removed, err := ss.Prune(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed = %v, want none", removed)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, last) {
+		t.Errorf("snap = %#v, want %#v", g.Metadata, last.Metadata)
+	}
+
+	// nor are the bases evicted to fit in the size limit
+	newest := &snappb.Snapshot{Data: []byte("newest"), Metadata: &snappb.SnapshotMetadata{Index: 4, Term: 1}}
+	if err = NewSnapshotter(dir, WithMaxBytes(1)).SaveSnap(newest); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range []uint64{1, 2} {
+		if _, err = os.Stat(filepath.Join(dir, ss.snapName(1, index))); err != nil {
+			t.Errorf("base was evicted: %v", err)
+		}
+	}
+
+	// an intact delta whose base is gone is not renamed to *.broken
+	if err = os.Remove(filepath.Join(dir, "0000000000000001-0000000000000001.snap")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ss.loadPrimarySnap(ss.snapName(1, 2)); !errors.Is(err, ErrDeltaBaseNotFound) || isCorruption(err) {
+		t.Errorf("err = %v, want %v", err, ErrDeltaBaseNotFound)
+	}
+	if _, err = os.Stat(filepath.Join(dir, ss.snapName(1, 2))); err != nil {
+		t.Errorf("delta was renamed: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// SnapshotDiff is the difference between two snapshots, b minus a.
+type SnapshotDiff struct {
+	TermDelta  int64
+	IndexDelta int64
+	// SizeDelta is the difference in the size of the snapshot data, in bytes.
+	SizeDelta int64
+}
+
+// Diff compares the metadata and data size of the snapshots a and b, e.g. to
+// measure how far a replica lags behind. A nil snapshot or metadata counts as
+// term and index zero.
+func Diff(a, b *snappb.Snapshot) SnapshotDiff {
+	return SnapshotDiff{
+		TermDelta:  int64(b.GetMetadata().GetTerm() - a.GetMetadata().GetTerm()),
+		IndexDelta: int64(b.GetMetadata().GetIndex() - a.GetMetadata().GetIndex()),
+		SizeDelta:  int64(len(b.GetData())) - int64(len(a.GetData())),
+	}
+}
+
+func (d SnapshotDiff) String() string {
+	return fmt.Sprintf("term %+d, index %+d, size %+d bytes", d.TermDelta, d.IndexDelta, d.SizeDelta)
+}
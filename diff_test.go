@@ -0,0 +1,43 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"testing"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestDiff(t *testing.T) {
+	a := &snappb.Snapshot{
+		Data:     []byte("some snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 10, Term: 2},
+	}
+	b := &snappb.Snapshot{
+		Data:     []byte("snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 4, Term: 3},
+	}
+
+	d := Diff(a, b)
+	if w := (SnapshotDiff{TermDelta: 1, IndexDelta: -6, SizeDelta: -5}); d != w {
+		t.Errorf("diff = %+v, want %+v", d, w)
+	}
+	if w := "term +1, index -6, size -5 bytes"; d.String() != w {
+		t.Errorf("string = %q, want %q", d.String(), w)
+	}
+	if w := (SnapshotDiff{TermDelta: 2, IndexDelta: 10, SizeDelta: 13}); Diff(nil, a) != w {
+		t.Errorf("diff = %+v, want %+v", Diff(nil, a), w)
+	}
+}
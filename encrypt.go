@@ -0,0 +1,73 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+)
+
+// encryptionMarker prefixes encrypted snap files. A serialized protobuf
+// message never starts with a zero byte (field number 0 is invalid), so
+// the marker cannot be mistaken for a plaintext snappb.SavedSnapshot.
+var encryptionMarker = []byte("\x00snapaes")
+
+// An encrypted snap file is laid out as:
+//
+//	encryptionMarker | nonce | AES-GCM sealed snappb.SavedSnapshot
+//
+// with the marker authenticated as additional data.
+
+func newAEAD(key [32]byte) cipher.AEAD {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	return aead
+}
+
+func isEncrypted(b []byte) bool {
+	return bytes.HasPrefix(b, encryptionMarker)
+}
+
+func encrypt(aead cipher.AEAD, b []byte) ([]byte, error) {
+	out := make([]byte, len(encryptionMarker)+aead.NonceSize(), len(encryptionMarker)+aead.NonceSize()+len(b)+aead.Overhead())
+	copy(out, encryptionMarker)
+	nonce := out[len(encryptionMarker):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, b, encryptionMarker), nil
+}
+
+func decrypt(aead cipher.AEAD, b []byte) ([]byte, error) {
+	b = b[len(encryptionMarker):]
+	if len(b) < aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+	nonce, sealed := b[:aead.NonceSize()], b[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, sealed, encryptionMarker)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plain, nil
+}
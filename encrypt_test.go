@@ -0,0 +1,114 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestSaveAndLoadEncrypted(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var key [32]byte
+	copy(key[:], "0123456789abcdef0123456789abcdef")
+	ss := NewSnapshotter(dir, WithEncryption(key))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(b, testSnap.Data) {
+		t.Errorf("expected the snap file not to contain the plaintext data")
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// a plaintext snap file is still readable by an encrypting snapshotter
+	newSnap := &snappb.Snapshot{
+		Data:     []byte("some snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 2, Term: 1},
+	}
+	if err = NewSnapshotter(dir).save(newSnap); err != nil {
+		t.Fatal(err)
+	}
+	if g, err = ss.Load(); err != nil {
+		t.Fatalf("err = %v, want nil", err)
+	}
+	if !proto.Equal(g, newSnap) {
+		t.Errorf("snap = %#v, want %#v", g, newSnap)
+	}
+
+	var wrongKey [32]byte
+	other := NewSnapshotter(dir, WithEncryption(wrongKey), WithBrokenRename(false))
+	if _, err = other.readSnap(fmt.Sprintf("%016x-%016x.snap", 1, 1)); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("err = %v, want %v", err, ErrDecryptionFailed)
+	}
+}
+
+func TestLoadWrongKeyNotRenamed(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var key, wrongKey [32]byte
+	copy(key[:], "0123456789abcdef0123456789abcdef")
+	if err = NewSnapshotter(dir, WithEncryption(key)).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	// a snap file encrypted with another key is not corrupt
+	for _, ss := range []*Snapshotter{NewSnapshotter(dir, WithEncryption(wrongKey)), NewSnapshotter(dir)} {
+		if _, err = ss.Load(); !errors.Is(err, ErrDecryptionFailed) {
+			t.Errorf("err = %v, want %v", err, ErrDecryptionFailed)
+		}
+	}
+	if _, err = os.Stat(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))); err != nil {
+		t.Errorf("err = %v, want the snap file to be left in place", err)
+	}
+	g, err := NewSnapshotter(dir, WithEncryption(key)).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
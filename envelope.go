@@ -0,0 +1,108 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// envelopeTrailerV1 marks the trailer appended to snap files, which protects
+// the whole file, envelope included, with a crc32c: the crc in the envelope
+// only covers the snapshot data. Snap files written before the trailer was
+// introduced end without it and are read as before.
+//
+//	file contents | envelopeTrailerV1 | crc32c of file contents (big endian)
+var envelopeTrailerV1 = []byte("\x00snapsv1")
+
+const envelopeTrailerLen = 8 + 4
+
+// envelopeCRCTable is not crcTable, so that the file checksum stays stable
+// whatever table the data crc uses.
+var envelopeCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+func appendTrailer(b []byte) []byte {
+	return append(b, envelopeTrailer(crc32.Checksum(b, envelopeCRCTable))...)
+}
+
+// envelopeTrailer returns the trailer of the snap file contents whose crc32c
+// is crc.
+func envelopeTrailer(crc uint32) []byte {
+	trailer := make([]byte, envelopeTrailerLen)
+	copy(trailer, envelopeTrailerV1)
+	binary.BigEndian.PutUint32(trailer[len(envelopeTrailerV1):], crc)
+	return trailer
+}
+
+// stripTrailer verifies and removes the trailer of the snap file contents b.
+// Contents without a trailer are returned unchanged.
+func stripTrailer(b []byte) ([]byte, error) {
+	if len(b) < envelopeTrailerLen {
+		return b, nil
+	}
+	trailer := b[len(b)-envelopeTrailerLen:]
+	if !bytes.Equal(trailer[:len(envelopeTrailerV1)], envelopeTrailerV1) {
+		return b, nil
+	}
+	b = b[:len(b)-envelopeTrailerLen]
+	if crc32.Checksum(b, envelopeCRCTable) != binary.BigEndian.Uint32(trailer[len(envelopeTrailerV1):]) {
+		return nil, ErrEnvelopeCorrupt
+	}
+	return b, nil
+}
+
+// LoadEnvelope returns the envelope of the snapshot with the given term and
+// index, decrypted if needed, without decoding the snapshot itself, e.g. to
+// re-wrap its data as it is. The checksum of the data is verified. A missing
+// snapshot is reported with an error wrapping ErrNoSnapshot, a corrupt one
+// with a *CorruptSnapshotError.
+func (s *Snapshotter) LoadEnvelope(term, index uint64) (*snappb.SavedSnapshot, error) {
+	return s.loadEnvelope(term, index, true)
+}
+
+// LoadEnvelopeUnverified is like LoadEnvelope, but skips the verification of
+// the length and checksum of the data.
+func (s *Snapshotter) LoadEnvelopeUnverified(term, index uint64) (*snappb.SavedSnapshot, error) {
+	return s.loadEnvelope(term, index, false)
+}
+
+func (s *Snapshotter) loadEnvelope(term, index uint64, verify bool) (*snappb.SavedSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := s.snapName(term, index)
+	b, err := s.readFile(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: term %d, index %d", ErrNoSnapshot, term, index)
+		}
+		return nil, err
+	}
+	snapname := filepath.Join(s.dir, name)
+	saved, err := s.decodeEnvelope(snapname, b)
+	if err == nil && verify {
+		err = s.verifyEnvelope(snapname, saved)
+	}
+	if err != nil {
+		return nil, &CorruptSnapshotError{Path: snapname, Err: err}
+	}
+	return saved, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestEnvelopeCorrupt(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	fpath := filepath.Join(dir, name)
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the tag of the first envelope field is outside of the data crc
+	corrupt := append([]byte(nil), b...)
+	corrupt[0] ^= 0x80
+	if err = ioutil.WriteFile(fpath, corrupt, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.readSnap(name); !errors.Is(err, ErrEnvelopeCorrupt) {
+		t.Errorf("err = %v, want %v", err, ErrEnvelopeCorrupt)
+	}
+
+	// a snap file written before the trailer was introduced is still read
+	if err = ioutil.WriteFile(fpath, b[:len(b)-envelopeTrailerLen], 0666); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ss.readSnap(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func TestLoadEnvelope(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if _, err = ss.LoadEnvelope(1, 1); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ss.LoadEnvelope(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g snappb.Snapshot
+	if err = proto.Unmarshal(saved.Data, &g); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", &g, testSnap)
+	}
+
+	// a crc mismatch is only reported when verifying
+	saved.Crc++
+	b, err := proto.Marshal(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)), appendTrailer(b), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.LoadEnvelope(1, 1); !errors.Is(err, ErrCRCMismatch) {
+		t.Errorf("err = %v, want %v", err, ErrCRCMismatch)
+	}
+	if _, err = ss.LoadEnvelopeUnverified(1, 1); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
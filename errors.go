@@ -0,0 +1,114 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CorruptSnapshotError records a snap file that could not be decoded
+// and the reason, e.g. ErrCRCMismatch.
+type CorruptSnapshotError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptSnapshotError) Error() string {
+	return fmt.Sprintf("%v (path: %s)", e.Err, e.Path)
+}
+
+func (e *CorruptSnapshotError) Unwrap() error { return e.Err }
+
+// BrokenRenameError records a snap file that failed to load with Err and could
+// not be renamed to *.broken afterwards, because of RenameErr. It unwraps to
+// Err, while errors.Is and errors.As match RenameErr as well.
+type BrokenRenameError struct {
+	Err       error
+	RenameErr error
+}
+
+func (e *BrokenRenameError) Error() string {
+	return fmt.Sprintf("%v (failed to rename to a broken snap file: %v)", e.Err, e.RenameErr)
+}
+
+func (e *BrokenRenameError) Unwrap() error { return e.Err }
+
+func (e *BrokenRenameError) Is(target error) bool { return errors.Is(e.RenameErr, target) }
+
+func (e *BrokenRenameError) As(target interface{}) bool { return errors.As(e.RenameErr, target) }
+
+// deltaBaseError records that the base of a delta snapshot failed to load.
+// The snap file of the delta itself may well be intact, so the error is not
+// taken for a corruption of it, see isCorruption.
+type deltaBaseError struct {
+	err error
+}
+
+func (e *deltaBaseError) Error() string { return e.err.Error() }
+
+func (e *deltaBaseError) Unwrap() error { return e.err }
+
+// noSnapshotError is returned instead of ErrNoSnapshot when some of
+// the candidate snap files were corrupt. It matches ErrNoSnapshot and
+// unwraps to the error of the newest corrupt candidate.
+type noSnapshotError struct {
+	err error
+}
+
+func (e *noSnapshotError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrNoSnapshot, e.err)
+}
+
+func (e *noSnapshotError) Is(target error) bool { return target == ErrNoSnapshot }
+
+func (e *noSnapshotError) Unwrap() error { return e.err }
+
+// noSnapshotDirError reports that the snapshot directory does not exist. It
+// matches ErrNoSnapshotDir, and the os.ErrNotExist the directory failed to be
+// listed with.
+type noSnapshotDirError struct {
+	err error
+}
+
+func (e *noSnapshotDirError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrNoSnapshotDir, e.err)
+}
+
+func (e *noSnapshotDirError) Is(target error) bool { return target == ErrNoSnapshotDir }
+
+func (e *noSnapshotDirError) Unwrap() error { return e.err }
+
+// noMatchingSnapshotError is the type of ErrNoMatchingSnapshot, which matches
+// ErrNoSnapshot as well, so that callers checking for the latter keep treating
+// a directory without a matching snapshot as one without a snapshot.
+type noMatchingSnapshotError struct {
+	msg string
+}
+
+func (e *noMatchingSnapshotError) Error() string { return e.msg }
+
+func (e *noMatchingSnapshotError) Is(target error) bool { return target == ErrNoSnapshot }
+
+// emptySnapshotError is the type of ErrEmptyFile and ErrEmptyData, which
+// both match ErrEmptySnapshot, so that callers not interested in telling
+// them apart can keep checking for the latter.
+type emptySnapshotError struct {
+	msg string
+}
+
+func (e *emptySnapshotError) Error() string { return e.msg }
+
+func (e *emptySnapshotError) Is(target error) bool { return target == ErrEmptySnapshot }
@@ -0,0 +1,189 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// Field numbers of the messages of snap files written by upstream etcd:
+//
+//	snappb.Snapshot         {crc = 1, data = 2}, data is a raftpb.Snapshot
+//	raftpb.Snapshot         {data = 1, metadata = 2}
+//	raftpb.SnapshotMetadata {conf_state = 1, index = 2, term = 3}
+//
+// crc is the crc32c of data.
+const (
+	etcdCRCField      protowire.Number = 1
+	etcdDataField     protowire.Number = 2
+	etcdRaftDataField protowire.Number = 1
+	etcdRaftMetaField protowire.Number = 2
+	etcdIndexField    protowire.Number = 2
+	etcdTermField     protowire.Number = 3
+)
+
+// LoadEtcdCompat is like Load, but also reads the snap files written by
+// upstream etcd, e.g. while migrating from it, whose envelope and snapshot are
+// laid out differently. Each snap file is decoded in the native format first,
+// then in the etcd one, so that a directory mixing both loads its newest
+// snapshot either way; a snapshot is only accepted if its metadata matches its
+// file name, since an etcd snap file decodes in the native format, with garbled
+// metadata. The conf state of etcd snapshots is dropped. Snap files that fail
+// to load in both formats are skipped, and left in place.
+func (s *Snapshotter) LoadEtcdCompat() (*snappb.Snapshot, error) {
+	snap, name, err := s.loadEtcdCompat()
+	if err != nil {
+		return nil, err
+	}
+	s.runHook("OnLoad", s.onLoad, snap.Metadata.Term, snap.Metadata.Index, filepath.Join(s.dir, name))
+	return snap, nil
+}
+
+func (s *Snapshotter) loadEtcdCompat() (*snappb.Snapshot, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.snapnames()
+	if err != nil {
+		return nil, "", err
+	}
+	var corruptErr error
+	for _, name := range names {
+		snap, err := s.readSnapCompat(name)
+		if err == nil {
+			return snap, name, nil
+		}
+		s.lg.Warn().Err(err).Str("path", filepath.Join(s.dir, name)).Msg("failed to read a snap file in either format")
+		if corruptErr == nil {
+			corruptErr = err
+		}
+	}
+	return nil, "", &noSnapshotError{err: corruptErr}
+}
+
+// readSnapCompat reads the snap file name, in the native format or else in the
+// etcd one, see LoadEtcdCompat.
+func (s *Snapshotter) readSnapCompat(name string) (*snappb.Snapshot, error) {
+	term, index, err := s.parseSnapName(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.readFile(name)
+	if err != nil {
+		return nil, err
+	}
+	snapname := filepath.Join(s.dir, name)
+	matches := func(snap *snappb.Snapshot) bool {
+		return snap.Metadata.GetTerm() == term && snap.Metadata.GetIndex() == index
+	}
+	snap, _, err := s.decodeSnap(snapname, b)
+	if err == nil && matches(snap) {
+		return snap, nil
+	}
+	esnap, eerr := decodeEtcdSnap(b)
+	if eerr == nil && matches(esnap) {
+		return esnap, nil
+	}
+	if err == nil {
+		err = fmt.Errorf("snap: snapshot at term %d, index %d does not match its file name", snap.Metadata.GetTerm(), snap.Metadata.GetIndex())
+	}
+	return nil, &CorruptSnapshotError{Path: snapname, Err: err}
+}
+
+// decodeEtcdSnap decodes the content b of a snap file written by upstream etcd.
+func decodeEtcdSnap(b []byte) (*snappb.Snapshot, error) {
+	if len(b) == 0 {
+		return nil, ErrEmptyFile
+	}
+	var crc uint64
+	var data []byte
+	err := walkFields(b, func(num protowire.Number, typ protowire.Type, v uint64, bs []byte) {
+		switch {
+		case num == etcdCRCField && typ == protowire.VarintType:
+			crc = v
+		case num == etcdDataField && typ == protowire.BytesType:
+			data = bs
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptyData
+	}
+	if crc32.Checksum(data, envelopeCRCTable) != uint32(crc) {
+		return nil, ErrCRCMismatch
+	}
+
+	snap := &snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{}}
+	var meta []byte
+	err = walkFields(data, func(num protowire.Number, typ protowire.Type, v uint64, bs []byte) {
+		switch {
+		case num == etcdRaftDataField && typ == protowire.BytesType:
+			snap.Data = bs
+		case num == etcdRaftMetaField && typ == protowire.BytesType:
+			meta = bs
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = walkFields(meta, func(num protowire.Number, typ protowire.Type, v uint64, bs []byte) {
+		switch {
+		case num == etcdIndexField && typ == protowire.VarintType:
+			snap.Metadata.Index = v
+		case num == etcdTermField && typ == protowire.VarintType:
+			snap.Metadata.Term = v
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// walkFields calls fn with every field of the protobuf message b, along with
+// its value if it is a varint, or its content if it is length-delimited.
+func walkFields(b []byte, fn func(num protowire.Number, typ protowire.Type, v uint64, bs []byte)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v uint64
+		var bs []byte
+		switch typ {
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			bs, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		fn(num, typ, v, bs)
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// etcdSnapFile returns the content of the snap file upstream etcd writes for
+// a snapshot with the given data, term and index, and an empty conf state.
+func etcdSnapFile(data []byte, term, index uint64) []byte {
+	var meta []byte
+	meta = protowire.AppendTag(meta, 1, protowire.BytesType)
+	meta = protowire.AppendBytes(meta, nil)
+	meta = protowire.AppendTag(meta, etcdIndexField, protowire.VarintType)
+	meta = protowire.AppendVarint(meta, index)
+	meta = protowire.AppendTag(meta, etcdTermField, protowire.VarintType)
+	meta = protowire.AppendVarint(meta, term)
+
+	var raft []byte
+	raft = protowire.AppendTag(raft, etcdRaftDataField, protowire.BytesType)
+	raft = protowire.AppendBytes(raft, data)
+	raft = protowire.AppendTag(raft, etcdRaftMetaField, protowire.BytesType)
+	raft = protowire.AppendBytes(raft, meta)
+
+	var b []byte
+	b = protowire.AppendTag(b, etcdCRCField, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(crc32.Checksum(raft, crc32.MakeTable(crc32.Castagnoli))))
+	b = protowire.AppendTag(b, etcdDataField, protowire.BytesType)
+	return protowire.AppendBytes(b, raft)
+}
+
+func TestLoadEtcdCompat(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	etcdSnap := &snappb.Snapshot{
+		Data:     []byte("etcd snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 5, Term: 2},
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 2, 5)), etcdSnapFile(etcdSnap.Data, 2, 5), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.LoadEtcdCompat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, etcdSnap) {
+		t.Errorf("snap = %#v, want %#v", g, etcdSnap)
+	}
+
+	// a corrupt etcd snap file is skipped for the older native one
+	b := etcdSnapFile(etcdSnap.Data, 2, 5)
+	b[len(b)-1] ^= 0xff
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 2, 5)), b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if g, err = ss.LoadEtcdCompat(); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	if err = os.Remove(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.LoadEtcdCompat(); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"hash/crc32"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// The numbers of the data fields of snappb.Snapshot and snappb.SavedSnapshot,
+// which hold the bulk of a snap file.
+const (
+	snapshotDataField      protowire.Number = 1
+	savedSnapshotDataField protowire.Number = 2
+)
+
+// encodeSnapStreamed is encodeSnap for snap files that are neither compressed
+// nor encrypted, which are encoded without copying the snapshot data: the
+// envelope and the snapshot are framed around snapshot.Data, with their data
+// field last, and the checksums are computed over the frames as they are. The
+// returned buffers are the frames, snapshot.Data itself, and the trailer, so that
+// the largest snapshots are not held two or three times in memory while saved.
+// The result decodes as any other snap file.
+func (s *Snapshotter) encodeSnapStreamed(snapshot *snappb.Snapshot, base *snappb.SnapshotMetadata, start time.Time) ([][]byte, SaveInfo, error) {
+	inner, err := proto.Marshal(&snappb.Snapshot{Metadata: snapshot.Metadata})
+	if err != nil {
+		panic(err)
+	}
+	inner = protowire.AppendTag(inner, snapshotDataField, protowire.BytesType)
+	inner = protowire.AppendVarint(inner, uint64(len(snapshot.Data)))
+	dataLen := len(inner) + len(snapshot.Data)
+
+	table, poly := s.writeCRCTable()
+	crc, sum, err := computeChecksum(s.checksum, table, inner, snapshot.Data)
+	if err != nil {
+		s.lg.Warn().Err(err).Str("algo", s.checksum.String()).Msg("failed to compute snapshot checksum")
+		return nil, SaveInfo{}, err
+	}
+	head, err := proto.Marshal(&snappb.SavedSnapshot{
+		Crc:              crc,
+		Codec:            snappb.Codec_NONE,
+		Algo:             s.checksum,
+		Checksum:         sum,
+		CreatedUnixNanos: start.UnixNano(),
+		WriterId:         s.writerID,
+		DataLen:          uint64(dataLen),
+		BaseTerm:         base.GetTerm(),
+		BaseIndex:        base.GetIndex(),
+		CrcPoly:          poly,
+	})
+	if err != nil {
+		panic(err)
+	}
+	head = protowire.AppendTag(head, savedSnapshotDataField, protowire.BytesType)
+	head = protowire.AppendVarint(head, uint64(dataLen))
+
+	bufs := [][]byte{head, inner, snapshot.Data}
+	h := crc32.New(envelopeCRCTable)
+	for _, b := range bufs {
+		h.Write(b)
+	}
+	info := SaveInfo{UncompressedBytes: int64(dataLen), CompressedBytes: int64(dataLen)}
+	return append(bufs, envelopeTrailer(h.Sum32())), info, nil
+}
+
+// unmarshalAliased is like proto.Unmarshal, but returns the bytes field number
+// field of b instead of unmarshaling it into m: the returned slice is a slice
+// of b rather than a copy, so that the data of a snapshot is not held twice in
+// memory while it is decoded.
+func unmarshalAliased(b []byte, m proto.Message, field protowire.Number) ([]byte, error) {
+	var data, rest []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		if num == field && typ == protowire.BytesType {
+			v, vn := protowire.ConsumeBytes(b[n:])
+			if vn < 0 {
+				return nil, protowire.ParseError(vn)
+			}
+			// as with proto.Unmarshal, the last occurrence wins
+			data = v
+			b = b[n+vn:]
+			continue
+		}
+		vn := protowire.ConsumeFieldValue(num, typ, b[n:])
+		if vn < 0 {
+			return nil, protowire.ParseError(vn)
+		}
+		rest = append(rest, b[:n+vn]...)
+		b = b[n+vn:]
+	}
+	if err := proto.Unmarshal(rest, m); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// buffersLen returns the total length of bufs.
+func buffersLen(bufs [][]byte) int {
+	n := 0
+	for _, b := range bufs {
+		n += len(b)
+	}
+	return n
+}
+
+// concatBuffers returns the concatenation of bufs, which is bufs[0] itself
+// when there is a single buffer.
+func concatBuffers(bufs [][]byte) []byte {
+	if len(bufs) == 1 {
+		return bufs[0]
+	}
+	b := make([]byte, 0, buffersLen(bufs))
+	for _, buf := range bufs {
+		b = append(b, buf...)
+	}
+	return b
+}
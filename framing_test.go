@@ -0,0 +1,98 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestEncodeSnapStreamed(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	bufs, _, err := ss.encodeSnap("1.snap", testSnap, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bufs) != 4 || &bufs[2][0] != &testSnap.Data[0] {
+		t.Fatalf("bufs = %q, want the snapshot data to be written as it is", bufs)
+	}
+
+	// the snap file decodes as a regular envelope
+	b, err := stripTrailer(concatBuffers(bufs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if crc := crc32.Checksum(saved.Data, crcTable); crc != saved.Crc {
+		t.Errorf("crc = %x, want %x", crc, saved.Crc)
+	}
+	var g snappb.Snapshot
+	if err = proto.Unmarshal(saved.Data, &g); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(&g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", &g, testSnap)
+	}
+
+	// compressed snap files are still encoded as a whole
+	ss = NewSnapshotter(dir, WithCompression(ZstdLevel(3)))
+	if bufs, _, err = ss.encodeSnap("1.snap", testSnap, nil, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if len(bufs) != 1 {
+		t.Errorf("len(bufs) = %d, want 1", len(bufs))
+	}
+}
+
+func TestUnmarshalAliased(t *testing.T) {
+	b, err := proto.Marshal(&snappb.SavedSnapshot{Crc: 1, Data: []byte("data"), WriterId: "node"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	data, err := unmarshalAliased(b, &saved, savedSnapshotDataField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte("data")) || saved.Crc != 1 || saved.WriterId != "node" || saved.Data != nil {
+		t.Errorf("data = %q, saved = %+v, want the data apart from the other fields", data, &saved)
+	}
+	data[0] = 'D'
+	if !bytes.Contains(b, []byte("Data")) {
+		t.Errorf("expected the data to be a slice of the input")
+	}
+
+	if _, err = unmarshalAliased(b[:len(b)-1], &saved, savedSnapshotDataField); err == nil {
+		t.Errorf("err = nil, want an error for truncated input")
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// FsckReport is the outcome of Fsck. Files are named relative to the snapshot
+// directory.
+type FsckReport struct {
+	// Snapshots holds the result of verifying every snap file, newest first.
+	Snapshots []VerifyResult
+	// Corrupt lists the snap files that failed verification.
+	Corrupt []string
+	// Unreadable lists the snap files that failed to be read, e.g. because
+	// of an I/O error, rather than to decode. They are not repaired.
+	Unreadable []string
+	// Broken lists the *.broken files left by earlier loads.
+	Broken []string
+	// Orphans lists the orphaned temporary files, such as the db.tmp files of
+	// defragmentation and the .snap.tmp files of interrupted saves.
+	Orphans []string
+	// OrphanDBs lists the .snap.db files older than the newest valid snapshot,
+	// which ReleaseSnapDBs would delete.
+	OrphanDBs []string
+
+	// Removed lists the files removed by the repair.
+	Removed []string
+	// Renamed lists the corrupt snap files renamed to *.broken by the repair,
+	// by their original name.
+	Renamed []string
+}
+
+// Fsck checks the snapshot directory as a whole: it verifies every snap file,
+// as Verify does, and reports the broken files, orphaned temporary files and
+// orphaned .snap.db files it finds. With repair set, it also fixes what it
+// found: orphaned files are removed as when the directory is listed, orphaned
+// .snap.db files as by ReleaseSnapDBs for the newest valid snapshot, and
+// corrupt snap files are renamed to *.broken as when they fail to load. The
+// snap files that failed to be read rather than decode, and the *.broken files
+// themselves, are left alone; the latter are for RecoverBroken. The repair stops at the
+// first failure, whose error is returned along with the report so far.
+func (s *Snapshotter) Fsck(repair bool) (FsckReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var report FsckReport
+	filenames, err := s.backend.List()
+	if err != nil {
+		return report, err
+	}
+	sort.Strings(filenames)
+	var snaps []string
+	for _, name := range filenames {
+		_, broken := trimBrokenSuffix(name)
+		switch {
+		case s.isSnapName(name):
+			snaps = append(snaps, name)
+		case broken:
+			report.Broken = append(report.Broken, name)
+		case s.orphanType(name) != "":
+			report.Orphans = append(report.Orphans, name)
+		}
+	}
+
+	s.sortSnapNames(snaps)
+	var newest *snappb.Snapshot
+	for _, name := range snaps {
+		snap, err := s.readSnap(name)
+		report.Snapshots = append(report.Snapshots, VerifyResult{
+			Path: filepath.Join(s.dir, name),
+			OK:   err == nil,
+			Err:  err,
+		})
+		if err != nil && isCorruption(err) {
+			report.Corrupt = append(report.Corrupt, name)
+		} else if err != nil {
+			report.Unreadable = append(report.Unreadable, name)
+		} else if newest == nil {
+			newest = snap
+		}
+	}
+	if newest != nil {
+		if report.OrphanDBs, err = s.releasableSnapDBs(newest); err != nil {
+			return report, err
+		}
+	}
+	if !repair {
+		return report, nil
+	}
+
+	for _, name := range report.Orphans {
+		s.lg.Info().Str("path", name).Msg("found orphaned file; deleting")
+		if err = s.backend.Remove(name); err != nil && !os.IsNotExist(err) {
+			return report, fmt.Errorf("failed to remove orphaned file %s: %v", name, err)
+		}
+		s.orphanRemoved(s.orphanType(name), name)
+		report.Removed = append(report.Removed, name)
+	}
+	for _, name := range report.OrphanDBs {
+		s.lg.Info().Str("path", name).Msg("found orphaned .snap.db file; deleting")
+		if err = s.backend.Remove(name); err != nil && !os.IsNotExist(err) {
+			return report, fmt.Errorf("failed to remove orphaned .snap.db file %s: %v", name, err)
+		}
+		report.Removed = append(report.Removed, name)
+	}
+	for _, name := range report.Corrupt {
+		fpath := filepath.Join(s.dir, name)
+		broken := s.brokenName(name)
+		if err = s.backend.Rename(name, broken); err != nil {
+			return report, fmt.Errorf("failed to rename broken snap file %s: %v", name, err)
+		}
+		s.lg.Warn().Str("path", fpath).Str("broken-path", filepath.Join(s.dir, broken)).Msg("renamed to a broken snap file")
+		report.Renamed = append(report.Renamed, name)
+	}
+	return report, nil
+}
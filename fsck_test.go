@@ -0,0 +1,124 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestFsck(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	newSnap := proto.Clone(testSnap).(*snappb.Snapshot)
+	newSnap.Metadata.Index = 5
+	if err = ss.save(newSnap); err != nil {
+		t.Fatal(err)
+	}
+	bad := fmt.Sprintf("%016x-%016x.snap", 1, 6)
+	broken := fmt.Sprintf("%016x-%016x.snap.broken", 1, 2)
+	tmp := fmt.Sprintf("%016x-%016x.snap.tmp", 1, 7)
+	oldDB, newDB := ss.snapDBName(3), ss.snapDBName(5)
+	for _, name := range []string{bad, broken, tmp, "db.tmp.123", oldDB, newDB} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("bad data"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := ss.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Snapshots) != 2 || report.Snapshots[0].OK || !report.Snapshots[1].OK {
+		t.Errorf("snapshots = %+v, want %s corrupt and the saved snapshot ok", report.Snapshots, bad)
+	}
+	if w := []string{bad}; !reflect.DeepEqual(report.Corrupt, w) {
+		t.Errorf("corrupt = %v, want %v", report.Corrupt, w)
+	}
+	if w := []string{broken}; !reflect.DeepEqual(report.Broken, w) {
+		t.Errorf("broken = %v, want %v", report.Broken, w)
+	}
+	if w := []string{tmp, "db.tmp.123"}; !reflect.DeepEqual(report.Orphans, w) {
+		t.Errorf("orphans = %v, want %v", report.Orphans, w)
+	}
+	if w := []string{oldDB}; !reflect.DeepEqual(report.OrphanDBs, w) {
+		t.Errorf("orphan dbs = %v, want %v", report.OrphanDBs, w)
+	}
+	if len(report.Removed) != 0 || len(report.Renamed) != 0 {
+		t.Errorf("removed = %v, renamed = %v, want nothing repaired", report.Removed, report.Renamed)
+	}
+	if !fileutil.Exist(filepath.Join(dir, tmp)) || !fileutil.Exist(filepath.Join(dir, bad)) {
+		t.Errorf("expected the directory to be left untouched")
+	}
+
+	if report, err = ss.Fsck(true); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{tmp, "db.tmp.123", oldDB}; !reflect.DeepEqual(report.Removed, w) {
+		t.Errorf("removed = %v, want %v", report.Removed, w)
+	}
+	if w := []string{bad}; !reflect.DeepEqual(report.Renamed, w) {
+		t.Errorf("renamed = %v, want %v", report.Renamed, w)
+	}
+	for _, name := range report.Removed {
+		if fileutil.Exist(filepath.Join(dir, name)) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if fileutil.Exist(filepath.Join(dir, bad)) || !fileutil.Exist(filepath.Join(dir, bad+".broken")) {
+		t.Errorf("expected %s to be renamed to a broken snap file", bad)
+	}
+	if !fileutil.Exist(filepath.Join(dir, broken)) || !fileutil.Exist(filepath.Join(dir, newDB)) {
+		t.Errorf("expected %s and %s to be left in place", broken, newDB)
+	}
+}
+
+func TestFsckReadError(t *testing.T) {
+	be := &readFailBackend{MemBackend: NewMemBackend(), err: syscall.EIO}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if err := be.Write(name, []byte("some data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewSnapshotter("snapshot", WithBackend(be)).Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Corrupt) != 0 || len(report.Renamed) != 0 {
+		t.Errorf("corrupt, renamed = %v, %v, want none", report.Corrupt, report.Renamed)
+	}
+	if w := []string{name}; !reflect.DeepEqual(report.Unreadable, w) {
+		t.Errorf("unreadable = %v, want %v", report.Unreadable, w)
+	}
+	if _, err = be.Stat(name); err != nil {
+		t.Errorf("err = %v, want the unreadable snap file to be left in place", err)
+	}
+}
@@ -3,8 +3,11 @@ module github.com/amazingchow/photon-dance-snap
 go 1.15
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/golang/protobuf v1.4.3
+	github.com/klauspost/compress v1.11.4
 	github.com/prometheus/client_golang v1.9.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/rs/zerolog v1.20.0
 	google.golang.org/protobuf v1.25.0
 )
@@ -0,0 +1,93 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+// SnapshotHook is called with the term and index of a snapshot, and the path
+// of its snap file, once an operation on the snapshot succeeded. Hooks run
+// synchronously, after the Snapshotter released its lock, so they may call
+// back into the Snapshotter. A panic in a hook is recovered and logged.
+type SnapshotHook func(term, index uint64, path string)
+
+// runHook calls hook, unless it is nil, recovering from a panic in it.
+func (s *Snapshotter) runHook(name string, hook SnapshotHook, term, index uint64, path string) {
+	if hook == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.lg.Error().Interface("panic", r).Str("hook", name).Str("path", path).Msg("recovered from a panic in a snapshot hook")
+		}
+	}()
+	hook(term, index, path)
+}
+
+// SaveInfo describes a saved snapshot, see WithOnSaveInfo.
+type SaveInfo struct {
+	Term, Index uint64
+	// Path is the path of the snap file.
+	Path string
+	// Size is the size of the snap file, envelope included.
+	Size int64
+	// UncompressedBytes and CompressedBytes are the sizes of the snapshot
+	// before and after compression, equal when it is not compressed.
+	UncompressedBytes, CompressedBytes int64
+}
+
+// Ratio returns the compression ratio of the snapshot, i.e. UncompressedBytes
+// over CompressedBytes, 1 when it is not compressed.
+func (i SaveInfo) Ratio() float64 {
+	if i.CompressedBytes == 0 {
+		return 1
+	}
+	return float64(i.UncompressedBytes) / float64(i.CompressedBytes)
+}
+
+// SaveHook is called with the SaveInfo of a snapshot once it was saved. Like a
+// SnapshotHook, it runs after the Snapshotter released its lock, and a panic in
+// it is recovered and logged.
+type SaveHook func(info SaveInfo)
+
+// runSaveHook calls s.onSaveInfo, unless it is nil, recovering from a panic in it.
+func (s *Snapshotter) runSaveHook(info SaveInfo) {
+	if s.onSaveInfo == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.lg.Error().Interface("panic", r).Str("hook", "OnSaveInfo").Str("path", info.Path).Msg("recovered from a panic in a snapshot hook")
+		}
+	}()
+	s.onSaveInfo(info)
+}
+
+// OrphanHook is called with the path of an orphaned file, such as a db.tmp file
+// left behind by an interrupted defragmentation, once it was removed from the
+// snapshot directory. Unlike a SnapshotHook, it runs while the Snapshotter holds
+// its lock, so it must not call back into the Snapshotter. A panic in it is
+// recovered and logged.
+type OrphanHook func(path string)
+
+// runOrphanHook calls s.onOrphan, unless it is nil, recovering from a panic in it.
+func (s *Snapshotter) runOrphanHook(path string) {
+	if s.onOrphan == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			s.lg.Error().Interface("panic", r).Str("hook", "OnOrphan").Str("path", path).Msg("recovered from a panic in a snapshot hook")
+		}
+	}()
+	s.onOrphan(path)
+}
@@ -0,0 +1,182 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestHooks(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var events []string
+	record := func(event string) SnapshotHook {
+		return func(term, index uint64, path string) {
+			events = append(events, fmt.Sprintf("%s %d/%d %s", event, term, index, filepath.Base(path)))
+		}
+	}
+	ss := NewSnapshotter(dir, WithOnSave(record("save")), WithOnLoad(record("load")), WithOnDelete(record("delete")))
+	for index := uint64(1); index <= 2; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.SaveSnap(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.DeleteSnap(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.LoadByIndex(3); err == nil {
+		t.Fatal("err = nil, want an error for a missing snapshot")
+	}
+
+	w := []string{
+		fmt.Sprintf("save 1/1 %016x-%016x.snap", 1, 1),
+		fmt.Sprintf("save 1/2 %016x-%016x.snap", 1, 2),
+		fmt.Sprintf("load 1/2 %016x-%016x.snap", 1, 2),
+		fmt.Sprintf("delete 1/2 %016x-%016x.snap", 1, 2),
+	}
+	if !reflect.DeepEqual(events, w) {
+		t.Errorf("events = %v, want %v", events, w)
+	}
+}
+
+func TestHookPanic(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithOnSave(func(uint64, uint64, string) { panic("hook failed") }))
+	if err = ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	// the snapshotter is still usable, its lock was released
+	if _, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnSaveInfo(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var infos []SaveInfo
+	record := WithOnSaveInfo(func(info SaveInfo) { infos = append(infos, info) })
+	snap := &snappb.Snapshot{
+		Data:     bytes.Repeat([]byte("some snapshot"), 1000),
+		Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1},
+	}
+	if err = NewSnapshotter(dir, record).SaveSnap(snap); err != nil {
+		t.Fatal(err)
+	}
+	snap.Metadata = &snappb.SnapshotMetadata{Index: 2, Term: 1}
+	if err = NewSnapshotter(dir, record, WithCompression(ZstdLevel(3))).SaveSnap(snap); err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("len(infos) = %d, want 2", len(infos))
+	}
+
+	size := int64(proto.Size(snap))
+	for i, info := range infos {
+		if info.Index != uint64(i+1) {
+			t.Errorf("#%d: index = %d, want %d", i, info.Index, i+1)
+		}
+		if w := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, i+1)); info.Path != w {
+			t.Errorf("#%d: path = %s, want %s", i, info.Path, w)
+		}
+		fi, err := os.Stat(info.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != fi.Size() {
+			t.Errorf("#%d: size = %d, want %d", i, info.Size, fi.Size())
+		}
+		if info.UncompressedBytes != size {
+			t.Errorf("#%d: uncompressed = %d, want %d", i, info.UncompressedBytes, size)
+		}
+	}
+	if infos[0].CompressedBytes != size || infos[0].Ratio() != 1 {
+		t.Errorf("compressed = %d, ratio = %f, want %d, 1", infos[0].CompressedBytes, infos[0].Ratio(), size)
+	}
+	if infos[1].CompressedBytes >= size || infos[1].Ratio() <= 1 {
+		t.Errorf("compressed = %d, ratio = %f, want less than %d, more than 1", infos[1].CompressedBytes, infos[1].Ratio(), size)
+	}
+}
+
+func TestOnOrphan(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	orphans := []string{fmt.Sprintf("%016x-%016x.snap.tmp", 1, 2), "db.tmp.123"}
+	for _, name := range orphans {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("orphan"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var removed []string
+	ss := NewSnapshotter(dir, WithOnOrphan(func(path string) { removed = append(removed, filepath.Base(path)) }))
+	if err = ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	defrag := counterValue(t, snapOrphanRemovedTotal.WithLabelValues("defrag"))
+	tmp := counterValue(t, snapOrphanRemovedTotal.WithLabelValues("tmp"))
+	if _, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if !reflect.DeepEqual(removed, orphans) {
+		t.Errorf("removed = %v, want %v", removed, orphans)
+	}
+	if d := counterValue(t, snapOrphanRemovedTotal.WithLabelValues("defrag")) - defrag; d != 1 {
+		t.Errorf("removed defrag files = %v, want 1", d)
+	}
+	if d := counterValue(t, snapOrphanRemovedTotal.WithLabelValues("tmp")) - tmp; d != 1 {
+		t.Errorf("removed tmp files = %v, want 1", d)
+	}
+}
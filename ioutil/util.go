@@ -25,13 +25,24 @@ import (
 // but calls Sync before closing the file. WriteAndSyncFile guarantees the data
 // is synced if there is no error returned.
 func WriteAndSyncFile(filename string, data []byte, perm os.FileMode) error {
+	return WriteAndSyncBuffers(filename, [][]byte{data}, perm)
+}
+
+// WriteAndSyncBuffers is like WriteAndSyncFile, but writes the data from
+// several buffers, one after the other, without concatenating them.
+func WriteAndSyncBuffers(filename string, bufs [][]byte, perm os.FileMode) error {
 	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
 	if err != nil {
 		return err
 	}
-	n, err := f.Write(data)
-	if err == nil && n < len(data) {
-		err = io.ErrShortWrite
+	for _, data := range bufs {
+		var n int
+		if n, err = f.Write(data); err == nil && n < len(data) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			break
+		}
 	}
 	if err == nil {
 		err = fileutil.Fsync(f)
@@ -0,0 +1,65 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"io"
+	"os"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// SnapIterator walks the snapshots of a snapshot directory one at a time,
+// newest first, so that memory stays bounded however many there are.
+type SnapIterator struct {
+	s     *Snapshotter
+	names []string
+	// ReportCorrupt makes Next return the error of a snap file that fails
+	// to load, instead of skipping it. Iteration resumes with the next file.
+	ReportCorrupt bool
+}
+
+// Iterate returns an iterator over the snapshots in the snapshot directory as
+// of the call. Unlike Load, iterating leaves corrupt files in place.
+func (s *Snapshotter) Iterate() (*SnapIterator, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.snapnames()
+	if err != nil && err != ErrNoSnapshot {
+		return nil, err
+	}
+	return &SnapIterator{s: s, names: names}, nil
+}
+
+// Next decodes and returns the next snapshot, or io.EOF once all of them were
+// returned. Snap files removed since Iterate was called are skipped.
+func (it *SnapIterator) Next() (*snappb.Snapshot, error) {
+	it.s.mu.RLock()
+	defer it.s.mu.RUnlock()
+
+	for len(it.names) > 0 {
+		name := it.names[0]
+		it.names = it.names[1:]
+		snap, err := it.s.readSnap(name)
+		if err == nil {
+			return snap, nil
+		}
+		if it.ReportCorrupt && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return nil, io.EOF
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestIterate(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	it, err := ss.Iterate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = it.Next(); err != io.EOF {
+		t.Errorf("err = %v, want %v", err, io.EOF)
+	}
+
+	for index := uint64(1); index <= 3; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2)), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, report := range []bool{false, true} {
+		if it, err = ss.Iterate(); err != nil {
+			t.Fatal(err)
+		}
+		it.ReportCorrupt = report
+		var indexes []uint64
+		corrupt := 0
+		for {
+			snap, err := it.Next()
+			if err == io.EOF {
+				break
+			}
+			var cerr *CorruptSnapshotError
+			if errors.As(err, &cerr) {
+				corrupt++
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			indexes = append(indexes, snap.Metadata.Index)
+		}
+		if w := []uint64{3, 1}; !reflect.DeepEqual(indexes, w) {
+			t.Errorf("report %v: indexes = %v, want %v", report, indexes, w)
+		}
+		if w := map[bool]int{false: 0, true: 1}[report]; corrupt != w {
+			t.Errorf("report %v: corrupt = %d, want %d", report, corrupt, w)
+		}
+	}
+}
@@ -30,6 +30,24 @@ var (
 		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
 	})
 
+	snapSaveFailureSec = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "photon_dance",
+		Subsystem: "snap",
+		Name:      "save_failure_duration_seconds",
+		Help:      "The latency distributions of save called by snapshot that failed.",
+
+		// lowest bucket start of upper bound 0.001 sec (1 ms) with factor 2
+		// highest bucket start of 0.001 sec * 2^13 == 8.192 sec
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	snapSaveTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "photon_dance",
+		Subsystem: "snap",
+		Name:      "save_total",
+		Help:      "The total number of snapshot saves, by result (success or error).",
+	}, []string{"result"})
+
 	snapFsyncSec = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Namespace: "photon_dance",
 		Subsystem: "snap",
@@ -40,9 +58,51 @@ var (
 		// highest bucket start of 0.001 sec * 2^13 == 8.192 sec
 		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
 	})
+
+	snapDirFsyncSec = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "photon_dance",
+		Subsystem: "snap",
+		Name:      "dir_fsync_duration_seconds",
+		Help:      "The latency distributions of fsync called by snap on the snapshot directory.",
+
+		// lowest bucket start of upper bound 0.001 sec (1 ms) with factor 2
+		// highest bucket start of 0.001 sec * 2^13 == 8.192 sec
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	snapCompressionRatio = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "photon_dance",
+		Subsystem: "snap",
+		Name:      "compression_ratio",
+		Help:      "The distributions of the compression ratio of saved snapshots, uncompressed over compressed size (1 when not compressed).",
+
+		// lowest bucket start of upper bound 1 with factor 1.5
+		// highest bucket start of 1 * 1.5^11 == 86.5
+		Buckets: prometheus.ExponentialBuckets(1, 1.5, 12),
+	})
+
+	snapCorruptTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "photon_dance",
+		Subsystem: "snap",
+		Name:      "corrupt_total",
+		Help:      "The total number of snap files that failed to load, by type of failure (empty, empty_data, crc, unmarshal, payload or other).",
+	}, []string{"type"})
+
+	snapOrphanRemovedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "photon_dance",
+		Subsystem: "snap",
+		Name:      "orphan_removed_total",
+		Help:      "The total number of orphaned files removed from the snapshot directory, by type (defrag for db.tmp files, tmp for temporary snap files, custom for files matching an orphan pattern).",
+	}, []string{"type"})
 )
 
 func init() {
 	prometheus.MustRegister(snapSaveSec)
+	prometheus.MustRegister(snapSaveFailureSec)
+	prometheus.MustRegister(snapSaveTotal)
 	prometheus.MustRegister(snapFsyncSec)
+	prometheus.MustRegister(snapDirFsyncSec)
+	prometheus.MustRegister(snapCompressionRatio)
+	prometheus.MustRegister(snapCorruptTotal)
+	prometheus.MustRegister(snapOrphanRemovedTotal)
 }
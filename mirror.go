@@ -0,0 +1,114 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// writeMirror writes the snap file name, with content b, to the mirror. The
+// snap file is durable in the snapshot directory already, so a failure is only
+// logged.
+func (s *Snapshotter) writeMirror(name string, b ...[]byte) {
+	mpath := filepath.Join(s.mirrorDir, name)
+	if err := s.writeFileTo(s.mirror, name, b...); err != nil {
+		s.lg.Warn().Err(err).Str("path", mpath).Msg("failed to write a mirror snap file")
+		return
+	}
+	if ds, ok := s.mirror.(dirSyncer); ok && s.sync {
+		if err := ds.SyncDir(); err != nil {
+			s.lg.Warn().Err(err).Str("path", s.mirrorDir).Msg("failed to fsync the mirror directory")
+		}
+	}
+}
+
+// loadMirrorSnap loads the mirror copy of the snap file name, which failed to
+// load from the snapshot directory with perr. perr is returned if the mirror
+// copy fails to load as well.
+func (s *Snapshotter) loadMirrorSnap(name string, perr error) (*snappb.Snapshot, *snappb.SavedSnapshot, error) {
+	mpath := filepath.Join(s.mirrorDir, name)
+	f, err := openGzipped(s.mirror, name, strings.HasSuffix(name, gzSuffix))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.lg.Warn().Err(err).Str("path", mpath).Msg("failed to read a mirror snap file")
+		}
+		return nil, nil, perr
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", mpath).Msg("failed to read a mirror snap file")
+		return nil, nil, perr
+	}
+	snap, saved, err := s.decodeSnap(mpath, b)
+	if err != nil {
+		return nil, nil, perr
+	}
+	s.lg.Warn().Err(perr).Str("path", filepath.Join(s.dir, name)).Str("mirror-path", mpath).
+		Msg("failed to load a snap file; loaded its mirror copy")
+	return snap, saved, nil
+}
+
+// removeMirror removes the mirror copy of the snap file name, if any. A
+// failure is only logged.
+func (s *Snapshotter) removeMirror(name string) {
+	if s.mirror == nil || !s.isSnapName(name) {
+		return
+	}
+	if err := s.mirror.Remove(name); err != nil && !os.IsNotExist(err) {
+		s.lg.Warn().Err(err).Str("path", filepath.Join(s.mirrorDir, name)).Msg("failed to remove a mirror snap file")
+	}
+}
+
+// loadnames is like snapnames, but also returns the snap files only found in
+// the mirror, if any, so that they can be loaded from there. The mirror is
+// listed as well when the snapshot directory fails to be listed, e.g. when it
+// is missing, whose error is returned if the mirror has no snap files either.
+func (s *Snapshotter) loadnames() ([]string, error) {
+	names, err := s.snapnames()
+	if s.mirror == nil {
+		return names, err
+	}
+	if err != nil && err != ErrNoSnapshot {
+		s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to list the snapshot directory; listing the mirror")
+	}
+	filenames, merr := s.mirror.List()
+	if merr != nil {
+		s.lg.Warn().Err(merr).Str("path", s.mirrorDir).Msg("failed to list the mirror directory")
+		return names, err
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		seen[name] = true
+	}
+	for _, name := range s.checkSuffix(filenames) {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNoSnapshot
+	}
+	s.sortSnapNames(names)
+	return names, nil
+}
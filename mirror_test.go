@@ -0,0 +1,111 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+)
+
+func TestWithMirror(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mirror := filepath.Join(os.TempDir(), "snapshot-mirror")
+	defer os.RemoveAll(mirror)
+
+	// a missing mirror directory does not fail the save
+	ss := NewSnapshotter(dir, WithMirror(mirror))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if !fileutil.Exist(filepath.Join(mirror, name)) {
+		t.Fatalf("expected %s to be mirrored", name)
+	}
+
+	// a corrupt snap file is loaded from the mirror
+	if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	// and so is a missing one, here renamed to *.broken by the previous load
+	if fileutil.Exist(filepath.Join(dir, name)) {
+		t.Fatalf("expected %s to be renamed to a broken snap file", name)
+	}
+	if g, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.DeleteSnap(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fileutil.Exist(filepath.Join(mirror, name)) {
+		t.Errorf("expected %s to be removed from the mirror", name)
+	}
+	if _, err = ss.Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+
+	// a snapshot directory that fails to be listed falls back to the mirror
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if g, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	// and its error is kept when the mirror has no snap file either
+	if err = os.RemoveAll(mirror); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.Load(); !errors.Is(err, ErrNoSnapshotDir) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshotDir)
+	}
+}
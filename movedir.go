@@ -0,0 +1,205 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+)
+
+// MoveDir moves the snap files and .snap.db files of the snapshot directory to
+// newDir, e.g. to migrate to another volume, and makes the Snapshotter use
+// newDir from then on. newDir is created if needed, and must not hold files of
+// the same names. The files are renamed when newDir is on the same device, and
+// otherwise copied, checked to have the crc32 of their original, and only then
+// removed from the snapshot directory. If a rename or copy fails, the files
+// moved so far are moved back or removed, leaving the snapshot directory as it
+// was. Once the files are renamed, the Snapshotter uses newDir even if syncing
+// the directories fails, which MoveDir then reports. Other files, such as
+// *.broken files, are left behind. Only applies to snapshotters backed by a
+// FileBackend, whose temporary directory is kept if it is on the same device
+// as newDir, and otherwise defaults to newDir.
+func (s *Snapshotter) MoveDir(newDir string) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	fb, ok := s.backend.(*FileBackend)
+	if !ok {
+		return errors.New("snap: cannot move the snapshot directory of a custom backend")
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(newDir, s.dirMode); err != nil {
+		return err
+	}
+	filenames, err := s.backend.List()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, name := range filenames {
+		if s.isSnapName(name) || strings.HasSuffix(name, s.dbExt()) {
+			if _, err = os.Stat(filepath.Join(newDir, name)); err == nil {
+				return fmt.Errorf("%w: %s", ErrSnapshotExists, filepath.Join(newDir, name))
+			}
+			names = append(names, name)
+		}
+	}
+
+	same, err := fileutil.SameDevice(s.dir, newDir)
+	if err != nil {
+		return err
+	}
+	if same {
+		err = s.moveByRename(names, newDir)
+	} else {
+		err = s.moveByCopy(names, newDir)
+	}
+	if err != nil {
+		return err
+	}
+	s.lg.Info().Str("path", s.dir).Str("new-path", newDir).Int("count", len(names)).Msg("moved the snapshot directory")
+	s.dir = newDir
+	s.backend = s.movedBackend(fb, newDir)
+
+	// the renamed files are in newDir already, a failure to sync only puts
+	// their directory entries at risk
+	if same {
+		if err = s.backend.(dirSyncer).SyncDir(); err != nil {
+			return err
+		}
+		return fb.SyncDir()
+	}
+	return nil
+}
+
+// movedBackend returns a copy of the backend fb storing snap files in newDir.
+// The temporary directory of fb is kept unless it is the snapshot directory
+// itself or is on another device than newDir.
+func (s *Snapshotter) movedBackend(fb *FileBackend, newDir string) *FileBackend {
+	moved := *fb
+	moved.dir = newDir
+	if fb.tempDir == fb.dir {
+		moved.tempDir = newDir
+	} else if same, err := fileutil.SameDevice(newDir, fb.tempDir); err != nil || !same {
+		s.lg.Warn().Err(err).Str("path", fb.tempDir).Str("new-path", newDir).
+			Msg("temporary directory is not on the device of the new snapshot directory; defaulting to it")
+		moved.tempDir = newDir
+	}
+	return &moved
+}
+
+// moveByRename renames the named files from the snapshot directory to newDir,
+// renaming those already moved back if one fails.
+func (s *Snapshotter) moveByRename(names []string, newDir string) error {
+	for i, name := range names {
+		if err := os.Rename(filepath.Join(s.dir, name), filepath.Join(newDir, name)); err != nil {
+			s.lg.Warn().Err(err).Str("path", filepath.Join(s.dir, name)).Msg("failed to move a file; moving the others back")
+			for _, moved := range names[:i] {
+				if rerr := os.Rename(filepath.Join(newDir, moved), filepath.Join(s.dir, moved)); rerr != nil {
+					s.lg.Error().Err(rerr).Str("path", filepath.Join(newDir, moved)).Msg("failed to move a file back")
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// moveByCopy copies the named files from the snapshot directory to newDir,
+// checks the copies, and removes the originals once all of them are copied
+// and durable. If a copy or the sync of newDir fails, the copies made so far
+// are removed.
+func (s *Snapshotter) moveByCopy(names []string, newDir string) error {
+	for i, name := range names {
+		if err := copyFileVerified(filepath.Join(s.dir, name), filepath.Join(newDir, name)); err != nil {
+			s.lg.Warn().Err(err).Str("path", filepath.Join(s.dir, name)).Msg("failed to copy a file; removing the copies")
+			s.removeCopies(names[:i+1], newDir)
+			return err
+		}
+	}
+	if err := NewFileBackend(newDir).SyncDir(); err != nil {
+		s.lg.Warn().Err(err).Str("path", newDir).Msg("failed to fsync the new snapshot directory; removing the copies")
+		s.removeCopies(names, newDir)
+		return err
+	}
+	// the copies are durable, a failure to remove an original is only logged
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			s.lg.Warn().Err(err).Str("path", filepath.Join(s.dir, name)).Msg("failed to remove a moved file")
+		}
+	}
+	return nil
+}
+
+// removeCopies removes the copies in newDir of the named files.
+func (s *Snapshotter) removeCopies(names []string, newDir string) {
+	for _, copied := range names {
+		if rerr := os.Remove(filepath.Join(newDir, copied)); rerr != nil && !os.IsNotExist(rerr) {
+			s.lg.Error().Err(rerr).Str("path", filepath.Join(newDir, copied)).Msg("failed to remove a copy")
+		}
+	}
+}
+
+// copyFileVerified copies the file src to dst, fsyncs it, and checks that
+// dst reads back with the crc32 of src.
+func copyFileVerified(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	h := crc32.New(crcTable)
+	_, err = io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = fileutil.Fsync(out)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h2 := crc32.New(crcTable)
+	if _, err = io.Copy(h2, f); err != nil {
+		return err
+	}
+	if h2.Sum32() != h.Sum32() {
+		return fmt.Errorf("%w: copy %s of %s", ErrCRCMismatch, dst, src)
+	}
+	return nil
+}
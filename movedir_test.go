@@ -0,0 +1,159 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+)
+
+func TestMoveDir(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newDir := filepath.Join(os.TempDir(), "snapshot-moved", "snap")
+	defer os.RemoveAll(filepath.Dir(newDir))
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	db := fmt.Sprintf("%016x.snap.db", 1)
+	if err = ioutil.WriteFile(filepath.Join(dir, db), []byte("db"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "other"), []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ss.MoveDir(newDir); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ioutil.ReadDir(newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range names {
+		got = append(got, fi.Name())
+	}
+	if w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 1), db}; !reflect.DeepEqual(got, w) {
+		t.Errorf("names = %v, want %v", got, w)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "other")); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// moving back onto existing files is refused
+	if err = ioutil.WriteFile(filepath.Join(dir, db), []byte("db"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.MoveDir(dir); !errors.Is(err, ErrSnapshotExists) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotExists)
+	}
+}
+
+func TestMoveByCopy(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newDir := filepath.Join(os.TempDir(), "snapshot-moved")
+	if err = os.Mkdir(newDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(newDir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+
+	// a file that cannot be copied rolls back the copies made so far
+	if err = ss.moveByCopy([]string{name, "missing.snap.db"}, newDir); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+	if _, err = os.Stat(filepath.Join(newDir, name)); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+
+	if err = ss.moveByCopy([]string{name}, newDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want not exist", err)
+	}
+	if _, err = NewSnapshotter(newDir).Load(); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
+
+func TestMoveDirKeepsTempDir(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tempDir := filepath.Join(os.TempDir(), "snapshot-tmp")
+	if err = os.Mkdir(tempDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	newDir := filepath.Join(os.TempDir(), "snapshot-moved")
+	defer os.RemoveAll(newDir)
+
+	fb, err := NewFileBackendWithTempDir(dir, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := NewSnapshotter(dir, WithBackend(fb))
+	if err = ss.MoveDir(newDir); err != nil {
+		t.Fatal(err)
+	}
+	moved := ss.backend.(*FileBackend)
+	if moved.dir != newDir || moved.tempDir != tempDir {
+		t.Errorf("backend = %+v, want dir %s and temporary directory %s", moved, newDir, tempDir)
+	}
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(newDir, fmt.Sprintf("%016x-%016x.snap", 1, 1))); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NameCodec formats and parses the names of the snap files, which carry the
+// term and index of the snapshot they hold, e.g. to share a snapshot directory
+// with tooling expecting another naming scheme. Snapshot database files keep
+// their "%016x.snap.db" names, or "%016x<ext>.db" with WithExtension.
+type NameCodec interface {
+	// Format returns the name of the snap file for term and index.
+	Format(term, index uint64) string
+	// Parse returns the term and index of the snap file name, ok is false if
+	// name is not one of its snap file names.
+	Parse(name string) (term, index uint64, ok bool)
+}
+
+// defaultNameCodec names snap files "%016x-%016x.snap" after their term and
+// index, so that the names sort in the order of the snapshots. ext replaces
+// the ".snap" extension when set, see WithExtension.
+type defaultNameCodec struct {
+	ext string
+}
+
+func (c defaultNameCodec) extension() string {
+	if c.ext == "" {
+		return defaultExt
+	}
+	return c.ext
+}
+
+func (c defaultNameCodec) Format(term, index uint64) string {
+	return fmt.Sprintf("%016x-%016x%s", term, index, c.extension())
+}
+
+func (c defaultNameCodec) Parse(name string) (term, index uint64, ok bool) {
+	if !strings.HasSuffix(name, c.extension()) {
+		return 0, 0, false
+	}
+	parts := strings.Split(strings.TrimSuffix(name, c.extension()), "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	var err error
+	if term, err = strconv.ParseUint(parts[0], 16, 64); err != nil {
+		return 0, 0, false
+	}
+	if index, err = strconv.ParseUint(parts[1], 16, 64); err != nil {
+		return 0, 0, false
+	}
+	return term, index, true
+}
+
+// snapDBName returns the canonical snapshot database filename for the given index.
+func (s *Snapshotter) snapDBName(index uint64) string {
+	return fmt.Sprintf("%016x%s", index, s.dbExt())
+}
+
+// dbExt returns the extension of the snapshot database files, ".snap.db" by
+// default, which follows the extension of the snap files.
+func (s *Snapshotter) dbExt() string {
+	return s.ext + ".db"
+}
+
+// snapName returns the snap filename for the given term and index.
+func (s *Snapshotter) snapName(term, index uint64) string {
+	return s.names.Format(term, index)
+}
+
+// parseSnapName parses the term and index from a snap filename, optionally
+// followed by ".gz".
+func (s *Snapshotter) parseSnapName(name string) (term, index uint64, err error) {
+	term, index, ok := s.names.Parse(strings.TrimSuffix(name, gzSuffix))
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid snap filename %s", name)
+	}
+	return term, index, nil
+}
+
+// isSnapName reports whether name, optionally followed by ".gz", is the name
+// of a snap file. Any ".snap" file, or file with the extension set with
+// WithExtension, qualifies, so that files which do not parse are still tried
+// and reported when loading, unless it is a hidden file.
+func (s *Snapshotter) isSnapName(name string) bool {
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	name = strings.TrimSuffix(name, gzSuffix)
+	if strings.HasSuffix(name, s.ext) {
+		return true
+	}
+	_, _, ok := s.names.Parse(name)
+	return ok
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// indexNameCodec names snap files "snapshot-<index>.pb", dropping the term.
+type indexNameCodec struct{}
+
+func (indexNameCodec) Format(term, index uint64) string {
+	return fmt.Sprintf("snapshot-%d.pb", index)
+}
+
+func (indexNameCodec) Parse(name string) (term, index uint64, ok bool) {
+	if _, err := fmt.Sscanf(name, "snapshot-%d.pb", &index); err != nil {
+		return 0, 0, false
+	}
+	return 0, index, true
+}
+
+func TestWithNameCodec(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithNameCodec(indexNameCodec{}))
+	snaps := make([]*snappb.Snapshot, 2)
+	for i := range snaps {
+		snaps[i] = &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: uint64(i + 1), Term: 1},
+		}
+		if err = ss.save(snaps[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !fileutil.Exist(filepath.Join(dir, "snapshot-2.pb")) {
+		t.Errorf("expected snapshot-2.pb to be written")
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, snaps[1]) {
+		t.Errorf("snap = %#v, want %#v", g, snaps[1])
+	}
+	infos, err := ss.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Index != 2 || infos[1].Index != 1 {
+		t.Errorf("infos = %+v, want indexes 2 and 1", infos)
+	}
+	if err = ss.DeleteSnap(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if ss.Exists(1, 2) {
+		t.Errorf("exists = true after delete, want false")
+	}
+}
+
+func TestWithExtension(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithExtension(".snapshot"))
+	for index := uint64(1); index <= 2; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		if !fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snapshot", 1, index))) {
+			t.Errorf("expected the snap file of index %d to be written with the extension", index)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x.snapshot.db", index)), []byte("db"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// files with the default extension are not snapshots of ss
+	if err = NewSnapshotter(dir).save(&snappb.Snapshot{
+		Data:     []byte("some snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 2 {
+		t.Errorf("index = %d, want 2", g.Metadata.Index)
+	}
+	if err = ss.ReleaseSnapDBs(g); err != nil {
+		t.Fatal(err)
+	}
+	if fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x.snapshot.db", 1))) {
+		t.Errorf("expected the older .snapshot.db file to be released")
+	}
+	if !fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x.snapshot.db", 2))) {
+		t.Errorf("expected the .snapshot.db file of the snapshot to be kept")
+	}
+}
+
+func TestWithExtensionInvalid(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, ext := range []string{"", ".", "snap"} {
+		if g := NewSnapshotter(dir, WithExtension(ext)).ext; g != defaultExt {
+			t.Errorf("WithExtension(%q): ext = %q, want %q", ext, g, defaultExt)
+		}
+	}
+
+	ss := NewSnapshotter(dir, WithExtension(""))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	dbname := filepath.Join(dir, fmt.Sprintf("%016x.snap.db", 1))
+	if err = ioutil.WriteFile(dbname, []byte("db"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !fileutil.Exist(dbname) {
+		t.Errorf("expected the .snap.db file to be left as it is")
+	}
+}
+
+// decimalNameCodec names snap files "<term>-<index>.snap" in decimal, which
+// unlike the default names do not sort lexically in the order of the snapshots.
+type decimalNameCodec struct{}
+
+func (decimalNameCodec) Format(term, index uint64) string {
+	return fmt.Sprintf("%d-%d.snap", term, index)
+}
+
+func (decimalNameCodec) Parse(name string) (term, index uint64, ok bool) {
+	if _, err := fmt.Sscanf(name, "%d-%d.snap", &term, &index); err != nil || fmt.Sprintf("%d-%d.snap", term, index) != name {
+		return 0, 0, false
+	}
+	return term, index, true
+}
+
+func TestSortSnapNames(t *testing.T) {
+	ss := NewSnapshotter("snapshot", WithNameCodec(decimalNameCodec{}))
+	names := []string{"9-10.snap", "10-2.snap", "10-10.snap.gz", "bad.snap", "10-9.snap", "10-10.snap"}
+	ss.sortSnapNames(names)
+	w := []string{"bad.snap", "10-10.snap", "10-10.snap.gz", "10-9.snap", "10-2.snap", "9-10.snap"}
+	if !reflect.DeepEqual(names, w) {
+		t.Errorf("names = %v, want %v", names, w)
+	}
+}
+
+func TestLoadNumericOrder(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithNameCodec(decimalNameCodec{}))
+	newest := &snappb.Snapshot{Data: []byte("newest"), Metadata: &snappb.SnapshotMetadata{Term: 10, Index: 2}}
+	for _, snap := range []*snappb.Snapshot{
+		{Data: []byte("older"), Metadata: &snappb.SnapshotMetadata{Term: 9, Index: 10}},
+		newest,
+	} {
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, newest) {
+		t.Errorf("snap = %#v, want %#v", g, newest)
+	}
+}
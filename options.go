@@ -0,0 +1,345 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"hash/crc32"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// SnapshotterOption configures a Snapshotter.
+type SnapshotterOption func(*Snapshotter)
+
+// WithCompression compresses the snapshot payload before it is persisted.
+// (e.g. WithCompression(ZstdLevel(3)) to compress with zstd at level 3)
+func WithCompression(c Compression) SnapshotterOption {
+	return func(s *Snapshotter) { s.compression = c }
+}
+
+// WithChecksum selects the algorithm used to protect the snapshot data.
+// Defaults to crc32 Castagnoli.
+func WithChecksum(algo snappb.ChecksumAlgo) SnapshotterOption {
+	return func(s *Snapshotter) { s.checksum = algo }
+}
+
+// WithBackend persists the snap files in b instead of the snapshot directory.
+func WithBackend(b Backend) SnapshotterOption {
+	return func(s *Snapshotter) { s.backend = b }
+}
+
+// WithFileMode sets the permission bits of the snap files, defaults to 0666
+// (before umask). If the umask, or an existing file being overwritten, leaves
+// the file with different permissions, they are fixed up with an explicit chmod.
+func WithFileMode(perm os.FileMode) SnapshotterOption {
+	return func(s *Snapshotter) { s.fileMode = perm }
+}
+
+// WithDirMode sets the permission bits EnsureDir creates the snapshot
+// directory with, defaults to 0700.
+func WithDirMode(perm os.FileMode) SnapshotterOption {
+	return func(s *Snapshotter) { s.dirMode = perm }
+}
+
+// WithClock replaces the clock used to time snapshot operations.
+func WithClock(c Clock) SnapshotterOption {
+	return func(s *Snapshotter) { s.clock = c }
+}
+
+// WithLogger logs through lg instead of the global zerolog logger, e.g. to
+// attach fields identifying the Snapshotter to every message.
+func WithLogger(lg zerolog.Logger) SnapshotterOption {
+	return func(s *Snapshotter) { s.lg = &lg }
+}
+
+// WithBrokenRename controls whether snap files that fail to load are renamed
+// to *.broken, defaults to true. When disabled, loading still skips to the next
+// candidate but leaves the file in place for inspection. Only corrupt snap
+// files are renamed: one that fails to be read, e.g. with a transient I/O
+// error, or to be decrypted, is skipped but left in place either way.
+func WithBrokenRename(rename bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.brokenRename = rename }
+}
+
+// WithTimestampedBroken controls whether snap files that fail to load are
+// renamed to *.broken.<unix nanos> rather than *.broken, defaults to false.
+// When enabled, every corrupt version of a snap file re-created under the same
+// name is kept, instead of each one replacing the previous *.broken file.
+func WithTimestampedBroken(timestamped bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.brokenTimestamped = timestamped }
+}
+
+// WithEncryption encrypts the snap files with AES-256-GCM under key. Snap
+// files written without encryption can still be loaded, which allows to
+// migrate a snapshot directory gradually.
+func WithEncryption(key [32]byte) SnapshotterOption {
+	return func(s *Snapshotter) { s.aead = newAEAD(key) }
+}
+
+// WithLoadConcurrency decodes up to n candidate snap files concurrently while
+// looking for a snapshot to load, defaults to 1. The newest matching snapshot
+// is still returned, and corrupt files are handled one by one as before.
+func WithLoadConcurrency(n int) SnapshotterOption {
+	return func(s *Snapshotter) {
+		if n > 0 {
+			s.loadConcurrency = n
+		}
+	}
+}
+
+// WithWriterID records id in every snap file written, e.g. the ID of the
+// node, so that it can be told which node produced a snapshot.
+func WithWriterID(id string) SnapshotterOption {
+	return func(s *Snapshotter) { s.writerID = id }
+}
+
+// WithAllowZeroCRC accepts snap files whose crc is zero, as written by older
+// formats, instead of treating them as empty; their data is loaded without
+// being verified. Defaults to false.
+func WithAllowZeroCRC(allow bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.allowZeroCRC = allow }
+}
+
+// WithMaxBytes bounds the total size of the snap and .snap.db files: after
+// every save, the oldest snapshots and their database files are evicted until
+// the total fits in max bytes. The newest snapshot is never evicted, even when
+// it exceeds max on its own. Disabled by default.
+func WithMaxBytes(max int64) SnapshotterOption {
+	return func(s *Snapshotter) { s.maxBytes = max }
+}
+
+// WithNameCodec names the snap files with c instead of "%016x-%016x.snap".
+func WithNameCodec(c NameCodec) SnapshotterOption {
+	return func(s *Snapshotter) { s.names = c }
+}
+
+// WithExtension names the snap files with the extension ext, e.g. ".snapshot",
+// instead of ".snap", and the snapshot database files with ext followed by
+// ".db". A NameCodec set with WithNameCodec is left as it is, and must format
+// the names with ext itself. An extension that is empty or does not start with
+// a dot, which would match other files, is ignored.
+func WithExtension(ext string) SnapshotterOption {
+	return func(s *Snapshotter) {
+		if len(ext) < 2 || ext[0] != '.' {
+			return
+		}
+		s.ext = ext
+		if _, ok := s.names.(defaultNameCodec); ok {
+			s.names = defaultNameCodec{ext: ext}
+		}
+	}
+}
+
+// WithPayloadValidator validates every snapshot loaded with validate, e.g. to
+// check that its data deserializes into the state of the application, before
+// it is accepted. A snapshot validate returns an error for is skipped like a
+// corrupt one, reported with a *CorruptSnapshotError wrapping the error, but
+// its snap file is not renamed to *.broken. validate runs while the
+// Snapshotter holds its lock, so it must not call back into the Snapshotter,
+// and concurrently with itself with WithLoadConcurrency.
+func WithPayloadValidator(validate func(*snappb.Snapshot) error) SnapshotterOption {
+	return func(s *Snapshotter) { s.payloadValidator = validate }
+}
+
+// WithOnSave calls hook after every snapshot saved.
+func WithOnSave(hook SnapshotHook) SnapshotterOption {
+	return func(s *Snapshotter) { s.onSave = hook }
+}
+
+// WithOnSaveInfo calls hook after every snapshot saved, with its size before
+// and after compression, e.g. to tune the compression level on real data.
+func WithOnSaveInfo(hook SaveHook) SnapshotterOption {
+	return func(s *Snapshotter) { s.onSaveInfo = hook }
+}
+
+// WithOnLoad calls hook after every snapshot loaded.
+func WithOnLoad(hook SnapshotHook) SnapshotterOption {
+	return func(s *Snapshotter) { s.onLoad = hook }
+}
+
+// WithOnDelete calls hook after every snapshot removed by DeleteSnap or Prune,
+// or evicted to fit in WithMaxBytes.
+func WithOnDelete(hook SnapshotHook) SnapshotterOption {
+	return func(s *Snapshotter) { s.onDelete = hook }
+}
+
+// WithOnOrphan calls hook after every orphaned file removed from the snapshot
+// directory, such as a db.tmp file left behind by an interrupted defragmentation.
+func WithOnOrphan(hook OrphanHook) SnapshotterOption {
+	return func(s *Snapshotter) { s.onOrphan = hook }
+}
+
+// WithOrphanPatterns removes the files matching any of patterns from the
+// snapshot directory whenever it is listed, as is done for the db.tmp files left
+// behind by defragmentation and the temporary snap files of interrupted saves,
+// e.g. to clean up the "*.partial" files of other tools. A pattern containing
+// any of the filepath.Match meta characters is matched as a glob against the
+// file name, any other as a prefix of it. Snap files, .snap.db files and the
+// valid files are never removed, even if they match.
+func WithOrphanPatterns(patterns ...string) SnapshotterOption {
+	return func(s *Snapshotter) { s.orphanPatterns = append(s.orphanPatterns, patterns...) }
+}
+
+// WithValidFiles allows the named files, besides the built-in "db", in the
+// snapshot directory, so that they are not reported as unexpected.
+func WithValidFiles(names ...string) SnapshotterOption {
+	return func(s *Snapshotter) {
+		if s.validFiles == nil {
+			s.validFiles = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			s.validFiles[name] = true
+		}
+	}
+}
+
+// WithWriteTimeout gives up on a save whose write and fsync of the snap file
+// take longer than d, returning ErrWriteTimeout, e.g. so that a hung disk does
+// not stall the caller. As with a cancelled SaveSnapContext, the write keeps
+// running in the background and removes its file once it finishes.
+func WithWriteTimeout(d time.Duration) SnapshotterOption {
+	return func(s *Snapshotter) { s.writeTimeout = d }
+}
+
+// WithSync controls whether the snap files and the snapshot directory are
+// fsynced when saving, defaults to true. Disabling it speeds up tests, at the
+// cost of durability: a crash may lose or truncate recently saved snapshots.
+// A warning is logged when a Snapshotter is created with sync disabled.
+func WithSync(sync bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.sync = sync }
+}
+
+// WithSyncBatch coalesces the fsyncs of snap files saved in quick succession,
+// e.g. by a leader snapshotting many raft groups at once: a save only stages
+// its snap file, unsynced and under a temporary name, and the staged files are
+// fsynced and renamed to their name as a batch, followed by a single fsync of
+// the snapshot directory, once size files are staged or interval has passed
+// since the first of them was, whichever comes first, or when Flush is called.
+// Until flushed, a saved snapshot is neither durable nor visible to loads and
+// listings, so a crash loses the snapshots saved in the last interval. Zero
+// disables the corresponding bound. Only applies to backends supporting it,
+// such as FileBackend, and when sync is enabled; WithWriteTimeout and WithRetry
+// do not apply to staged writes.
+func WithSyncBatch(interval time.Duration, size int) SnapshotterOption {
+	return func(s *Snapshotter) {
+		s.batchInterval = interval
+		s.batchSize = size
+	}
+}
+
+// WithReadOnly makes loading leave the snapshot directory untouched, e.g. when
+// it is mounted read-only: snap files that fail to load are skipped without
+// being renamed to *.broken, and orphaned temporary files are not removed.
+// Saving and deleting snapshots are not affected.
+func WithReadOnly(readOnly bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.readOnly = readOnly }
+}
+
+// WithOverwrite controls whether saving a snapshot replaces the snap file of an
+// existing snapshot with the same term and index, defaults to true. When
+// disabled, such a save fails with an error wrapping ErrSnapshotExists, e.g. to
+// detect accidental double saves.
+func WithOverwrite(overwrite bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.overwrite = overwrite }
+}
+
+// WithMaxSnapshotBytes fails saves of snapshots whose marshaled size exceeds
+// max bytes with an error wrapping ErrSnapshotTooLarge, before anything is
+// written, e.g. so that a runaway state machine does not fill up the disk.
+// The size is taken before compression. Zero, the default, means unlimited.
+func WithMaxSnapshotBytes(max int64) SnapshotterOption {
+	return func(s *Snapshotter) { s.maxSnapshotBytes = max }
+}
+
+// WithSpaceCheck checks, before writing a snap file, that its size plus margin
+// bytes are available on the volume of the snapshot directory, and fails the
+// save with an error wrapping ErrInsufficientSpace otherwise, so that a full
+// volume does not leave a partially written file behind. The check is skipped
+// for backends that cannot tell their available space. Disabled by default.
+func WithSpaceCheck(margin int64) SnapshotterOption {
+	return func(s *Snapshotter) {
+		s.spaceCheck = true
+		s.spaceMargin = margin
+	}
+}
+
+// WithCRCTable computes the crc32 of new snap files with table instead of the
+// default Castagnoli one, e.g. crc32.IEEETable for interoperability with other
+// systems. Only applies to the crc32 checksum algorithm. The polynomial is
+// recorded in the snap files, so that they are verified with the right table
+// whatever the table of the reader.
+func WithCRCTable(table *crc32.Table) SnapshotterOption {
+	return func(s *Snapshotter) { s.crcTable = table }
+}
+
+// WithStrictWALMatch makes LoadNewestAvailable also check that the snapshot it
+// loads is the newest of the WAL snapshots it is given. If the snap file of a
+// newer one is on disk, possibly renamed to *.broken, it failed to load: the
+// load then fails with an error wrapping ErrNewerSnapshotCorrupt and naming the
+// file, rather than silently falling back to a stale snapshot. Defaults to false.
+func WithStrictWALMatch(strict bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.strictWALMatch = strict }
+}
+
+// WithMonotonicIndex fails saves of snapshots whose index is not greater than
+// the index of the latest snapshot, as told by Latest, with an error wrapping
+// ErrNonMonotonicIndex, e.g. to catch callers whose index went backwards.
+// Defaults to false.
+func WithMonotonicIndex(monotonic bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.monotonicIndex = monotonic }
+}
+
+// WithMirror saves a copy of every snap file to the directory dir as well, e.g.
+// on another disk, so that loading falls back to it when a snap file of the
+// snapshot directory is corrupt or missing. A failure to write the copy is
+// logged but does not fail the save. Snapshots deleted, pruned or evicted are
+// removed from the mirror too; .snap.db files are not mirrored.
+func WithMirror(dir string) SnapshotterOption {
+	return func(s *Snapshotter) {
+		s.mirror = NewFileBackend(dir)
+		s.mirrorDir = dir
+	}
+}
+
+// WithRetry makes up to attempts attempts to write and fsync a snap file, e.g. on
+// networked storage with transient failures, instead of giving up on the first
+// failure. The wait between attempts starts at backoff and doubles after every
+// attempt. A failed attempt leaves the previous snap file of the same name, if
+// any, in place. Only errors satisfying the predicate set with WithRetryIf are retried,
+// by default all but ENOSPC and EROFS. Note that WithWriteTimeout bounds the
+// attempts and waits as a whole.
+func WithRetry(attempts int, backoff time.Duration) SnapshotterOption {
+	return func(s *Snapshotter) {
+		if attempts > 0 {
+			s.retryAttempts = attempts
+		}
+		s.retryBackoff = backoff
+	}
+}
+
+// WithRetryIf makes WithRetry retry the write failures satisfying transient only.
+func WithRetryIf(transient func(error) bool) SnapshotterOption {
+	return func(s *Snapshotter) { s.retryIf = transient }
+}
+
+func (s *Snapshotter) applyOpts(opts []SnapshotterOption) {
+	for _, opt := range opts {
+		opt(s)
+	}
+}
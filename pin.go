@@ -0,0 +1,77 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+// snapKey identifies a snapshot by its term and index.
+type snapKey struct {
+	term, index uint64
+}
+
+// Pin keeps the snapshot with the given term and index, and its snapshot
+// database file, from being removed by Prune, PruneOlderThan, ReleaseSnapDBs,
+// CompactDBs and the eviction of WithMaxBytes until it is unpinned, e.g. while it is being
+// streamed to a follower. DeleteSnap still removes a pinned snapshot. Pins are
+// held in memory only, and do not survive a restart. Pins are counted: a
+// snapshot pinned twice, e.g. by two concurrent transfers, stays pinned until
+// it is unpinned twice.
+func (s *Snapshotter) Pin(term, index uint64) {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	if s.pins == nil {
+		s.pins = make(map[snapKey]int)
+	}
+	s.pins[snapKey{term, index}]++
+}
+
+// Unpin releases one pin taken on the snapshot with the given term and index
+// by Pin. It is a no-op if the snapshot is not pinned.
+func (s *Snapshotter) Unpin(term, index uint64) {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	key := snapKey{term, index}
+	if s.pins[key] <= 1 {
+		delete(s.pins, key)
+		return
+	}
+	s.pins[key]--
+}
+
+// isPinned reports whether the snap file name holds a pinned snapshot.
+func (s *Snapshotter) isPinned(name string) bool {
+	term, index, err := s.parseSnapName(name)
+	if err != nil {
+		return false
+	}
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	return s.pins[snapKey{term, index}] > 0
+}
+
+// isIndexPinned reports whether a pinned snapshot has the given index, so that
+// its snapshot database file is needed.
+func (s *Snapshotter) isIndexPinned(index uint64) bool {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+
+	for k := range s.pins {
+		if k.index == index {
+			return true
+		}
+	}
+	return false
+}
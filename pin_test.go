@@ -0,0 +1,84 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestPin(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	for index := uint64(1); index <= 3; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index)), []byte("snap file\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// pins are counted, e.g. for two concurrent transfers of a snapshot
+	ss.Pin(1, 1)
+	ss.Pin(1, 1)
+	ss.Unpin(1, 1)
+	// the snapshot database file of a pinned snapshot is neither released nor
+	// compacted
+	if err = ss.ReleaseSnapDBs(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.CompactDBs(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if !fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", 1))) || fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", 2))) {
+		t.Errorf("expected only the .snap.db file of the pinned snapshot to be kept")
+	}
+
+	removed, err := ss.Prune(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 2)}; !reflect.DeepEqual(removed, w) {
+		t.Errorf("removed = %v, want %v", removed, w)
+	}
+
+	ss.Unpin(1, 1)
+	// unpinning more often than pinning is harmless
+	ss.Unpin(1, 1)
+	if removed, err = ss.Prune(1); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 1), fmt.Sprintf("%016x.snap.db", 1)}; !reflect.DeepEqual(removed, w) {
+		t.Errorf("removed = %v, want %v", removed, w)
+	}
+}
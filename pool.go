@@ -0,0 +1,55 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"sync"
+
+	"github.com/golang/protobuf/proto" // nolint
+)
+
+// maxPooledBufSize bounds the capacity of the buffers kept in bufPool, so
+// that the pool does not retain the staging buffers of the largest snapshots.
+const maxPooledBufSize = 64 << 20
+
+// bufPool holds the buffers the snap files are staged in while they are
+// encoded, reused across saves rather than allocated afresh for every one.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// getBuf returns an empty buffer from bufPool.
+func getBuf() *[]byte {
+	return bufPool.Get().(*[]byte)
+}
+
+// putBuf returns bp to bufPool, reset. Its content must not be referenced
+// anymore.
+func putBuf(bp *[]byte) {
+	if cap(*bp) > maxPooledBufSize {
+		return
+	}
+	*bp = (*bp)[:0]
+	bufPool.Put(bp)
+}
+
+// marshalTo marshals m into the buffer bp, reusing its storage.
+func marshalTo(bp *[]byte, m proto.Message) {
+	pb := proto.NewBuffer((*bp)[:0])
+	if err := pb.Marshal(m); err != nil {
+		panic(err)
+	}
+	*bp = pb.Bytes()
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestEncodeSnapPooled(t *testing.T) {
+	for _, opt := range []SnapshotterOption{WithCompression(ZstdLevel(1)), WithEncryption([32]byte{})} {
+		ss := NewSnapshotter("snapshot", opt)
+		first, _, err := ss.encodeSnap("1.snap", testSnap, nil, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the pooled buffers released by the first encoding are reused
+		other := &snappb.Snapshot{Data: bytes.Repeat([]byte("x"), len(testSnap.Data)), Metadata: testSnap.Metadata}
+		if _, _, err = ss.encodeSnap("1.snap", other, nil, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		g, _, err := ss.decodeSnap("1.snap", concatBuffers(first))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(g, testSnap) {
+			t.Errorf("snap = %#v, want %#v", g, testSnap)
+		}
+	}
+}
+
+func benchmarkSave(b *testing.B, opts ...SnapshotterOption) {
+	ss := NewSnapshotter("snapshot", append([]SnapshotterOption{WithBackend(NewMemBackend())}, opts...)...)
+	snap := &snappb.Snapshot{
+		Data:     bytes.Repeat([]byte("some snapshot "), 1<<16),
+		Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1},
+	}
+	b.SetBytes(int64(len(snap.Data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ss.save(snap); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSaveCompressed(b *testing.B) {
+	benchmarkSave(b, WithCompression(ZstdLevel(1)))
+}
+
+func BenchmarkSaveEncrypted(b *testing.B) {
+	benchmarkSave(b, WithEncryption([32]byte{}))
+}
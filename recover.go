@@ -0,0 +1,187 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// RecoverBroken retries the snap files renamed to *.broken (or
+// *.broken.<unix nanos>, see WithTimestampedBroken) because they failed to
+// load, e.g. once a transient storage issue is fixed, and renames those that
+// now pass verification back to their original name. It returns the original
+// names of the recovered files. Files that still fail are left as they are, as
+// are those whose original name is in use again.
+func (s *Snapshotter) RecoverBroken() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filenames, err := s.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	// the newest of several broken versions of a snap file is tried first
+	sort.Sort(sort.Reverse(sort.StringSlice(filenames)))
+	var recovered []string
+	for _, name := range filenames {
+		orig, ok := trimBrokenSuffix(name)
+		if !ok || !s.isSnapName(orig) {
+			continue
+		}
+		fpath := filepath.Join(s.dir, name)
+		if _, err = s.backend.Stat(orig); err == nil {
+			s.lg.Warn().Str("path", fpath).Str("original-path", orig).Msg("original name of broken snap file is in use; skipping")
+			continue
+		}
+		if err = s.verifyBroken(name, orig); err != nil {
+			s.lg.Info().Err(err).Str("path", fpath).Msg("broken snap file still fails to load")
+			continue
+		}
+		if err = s.backend.Rename(name, orig); err != nil {
+			return recovered, err
+		}
+		s.lg.Info().Str("path", fpath).Str("original-path", orig).Msg("recovered broken snap file")
+		recovered = append(recovered, orig)
+	}
+	return recovered, nil
+}
+
+// verifyBroken decodes the broken snap file name, whose original name is orig.
+func (s *Snapshotter) verifyBroken(name, orig string) error {
+	f, err := s.openFileGzipped(name, strings.HasSuffix(orig, gzSuffix))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.decodeSnap(filepath.Join(s.dir, name), b)
+	return err
+}
+
+// Recrc rewrites the snap file name in the snapshot directory with its checksum
+// computed afresh, with the crc table of new snap files, e.g. to migrate files
+// whose crc was computed with a wrong polynomial by an older build. As it
+// recomputes rather than verifies the checksum, it is only safe on files whose
+// data is known to be intact: the file is rewritten only if its snapshot
+// decompresses and unmarshals cleanly, with metadata matching its name, and is
+// otherwise reported with a *CorruptSnapshotError and left untouched. Files
+// whose checksum is already correct are not rewritten.
+func (s *Snapshotter) Recrc(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	term, index, err := s.parseSnapName(name)
+	if err != nil {
+		return err
+	}
+	b, err := s.readFile(name)
+	if err != nil {
+		return err
+	}
+	snapname := filepath.Join(s.dir, name)
+	saved, err := s.decodeEnvelope(snapname, b)
+	if err == nil {
+		err = s.checkRecrc(saved, term, index)
+	}
+	if err != nil {
+		return &CorruptSnapshotError{Path: snapname, Err: err}
+	}
+
+	table, poly := s.writeCRCTable()
+	crc, sum, err := computeChecksum(saved.Algo, table, saved.Data)
+	if err != nil {
+		return err
+	}
+	if saved.Algo != snappb.ChecksumAlgo_CRC32C {
+		poly = 0
+	}
+	if crc == saved.Crc && bytes.Equal(sum, saved.Checksum) && poly == saved.CrcPoly {
+		return nil
+	}
+	prevCrc := saved.Crc
+	saved.Crc, saved.Checksum, saved.CrcPoly = crc, sum, poly
+
+	// decodeEnvelope succeeded, so the trailer, if any, is intact.
+	plain, _ := stripTrailer(b)
+	if b, err = proto.Marshal(saved); err != nil {
+		panic(err)
+	}
+	if isEncrypted(plain) {
+		if b, err = encrypt(s.aead, b); err != nil {
+			return err
+		}
+	}
+	b = appendTrailer(b)
+	if strings.HasSuffix(name, gzSuffix) {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(b); err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		b = buf.Bytes()
+	}
+	if err = s.writeFile(name, b); err != nil {
+		return err
+	}
+	if ds, ok := s.backend.(dirSyncer); ok && s.sync {
+		if err = ds.SyncDir(); err != nil {
+			s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to fsync the snapshot directory")
+		}
+	}
+	s.lg.Info().Str("path", snapname).Uint32("prev-crc", prevCrc).Uint32("new-crc", crc).Msg("rewrote snap file checksum")
+	return nil
+}
+
+// checkRecrc checks that the envelope saved of the snap file of the snapshot
+// with the given term and index holds a structurally valid snapshot.
+func (s *Snapshotter) checkRecrc(saved *snappb.SavedSnapshot, term, index uint64) error {
+	if n := saved.DataLen; n != 0 && uint64(len(saved.Data)) != n {
+		return ErrTruncatedSnapshot
+	}
+	if len(saved.Data) == 0 {
+		return ErrEmptyData
+	}
+	if saved.BaseIndex != 0 {
+		return fmt.Errorf("snap: cannot check the delta snapshot at term %d, index %d", term, index)
+	}
+	data, err := decompress(saved.Codec, saved.Data)
+	if err != nil {
+		return err
+	}
+	var snap snappb.Snapshot
+	if err = proto.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	if m := snap.Metadata; m == nil || m.Term != term || m.Index != index {
+		return fmt.Errorf("snap: snapshot metadata %v does not match term %d, index %d", snap.Metadata, term, index)
+	}
+	return nil
+}
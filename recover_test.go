@@ -0,0 +1,187 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestRecoverBroken(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	good := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if err = os.Rename(filepath.Join(dir, good), filepath.Join(dir, good+".broken")); err != nil {
+		t.Fatal(err)
+	}
+	bad := fmt.Sprintf("%016x-%016x.snap.broken", 1, 2)
+	if err = ioutil.WriteFile(filepath.Join(dir, bad), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := ss.RecoverBroken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{good}; !reflect.DeepEqual(recovered, w) {
+		t.Errorf("recovered = %v, want %v", recovered, w)
+	}
+	if !fileutil.Exist(filepath.Join(dir, good)) || !fileutil.Exist(filepath.Join(dir, bad)) {
+		t.Errorf("expected %s to be recovered and %s to be left in place", good, bad)
+	}
+	if _, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimestampedBroken(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := &stepClock{now: time.Unix(0, 0), step: time.Second}
+	ss := NewSnapshotter(dir, WithTimestampedBroken(true), WithClock(clock))
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	// two corrupt versions of the same snap file are both kept
+	var broken []string
+	for i := 0; i < 2; i++ {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("bad data"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ss.Load(); !errors.Is(err, ErrNoSnapshot) {
+			t.Fatalf("err = %v, want %v", err, ErrNoSnapshot)
+		}
+		broken = append(broken, fmt.Sprintf("%s.broken.%d", name, clock.now.UnixNano()))
+	}
+	for _, b := range broken {
+		if !fileutil.Exist(filepath.Join(dir, b)) {
+			t.Errorf("expected %s to exist", b)
+		}
+	}
+	if st, err := ss.Status(); err != nil || st.Broken != 2 {
+		t.Errorf("broken = %d, err = %v, want 2, nil", st.Broken, err)
+	}
+
+	// the newest broken version is recovered once it is intact again
+	if err = NewSnapshotter(dir).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(filepath.Join(dir, name), filepath.Join(dir, broken[1])); err != nil {
+		t.Fatal(err)
+	}
+	recovered, err := ss.RecoverBroken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{name}; !reflect.DeepEqual(recovered, w) {
+		t.Errorf("recovered = %v, want %v", recovered, w)
+	}
+	if !fileutil.Exist(filepath.Join(dir, broken[0])) {
+		t.Errorf("expected %s to be left in place", broken[0])
+	}
+}
+
+func TestRecrc(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	// rewrite the crc as computed with the IEEE polynomial
+	fpath := filepath.Join(dir, name)
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &saved); err != nil {
+		t.Fatal(err)
+	}
+	saved.Crc = crc32.ChecksumIEEE(saved.Data)
+	if b, err = proto.Marshal(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fpath, appendTrailer(b), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.VerifyCRC(name); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("err = %v, want %v", err, ErrCRCMismatch)
+	}
+
+	if err = ss.Recrc(name); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// a snapshot that does not unmarshal is left untouched
+	saved.Data = []byte("bad data")
+	saved.DataLen = uint64(len(saved.Data))
+	if b, err = proto.Marshal(&saved); err != nil {
+		t.Fatal(err)
+	}
+	b = appendTrailer(b)
+	bad := fmt.Sprintf("%016x-%016x.snap", 1, 2)
+	if err = ioutil.WriteFile(filepath.Join(dir, bad), b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	var cerr *CorruptSnapshotError
+	if err = ss.Recrc(bad); !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want a *CorruptSnapshotError", err)
+	}
+	g2, err := ioutil.ReadFile(filepath.Join(dir, bad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(g2, b) {
+		t.Errorf("expected %s to be left untouched", bad)
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// isTransientWriteError is the default predicate of WithRetry: write failures
+// are retried unless the volume is full or read-only.
+func isTransientWriteError(err error) bool {
+	return !errors.Is(err, syscall.ENOSPC) && !errors.Is(err, syscall.EROFS)
+}
+
+// writeFileWithRetry is like writeFileTo, writing to the backend be of the
+// directory dir, but retries failed writes as configured with WithRetry. A
+// failed attempt leaves nothing behind to remove, and in particular leaves the
+// previous file of the same name, if any, in place.
+func (s *Snapshotter) writeFileWithRetry(be Backend, dir, name string, data ...[]byte) error {
+	backoff := s.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := s.writeFileTo(be, name, data...)
+		if err == nil || attempt >= s.retryAttempts || !s.retryIf(err) {
+			return err
+		}
+		s.lg.Warn().Err(err).Str("path", filepath.Join(dir, name)).Int("attempt", attempt).Dur("backoff", backoff).Msg("failed to write a snap file; retrying")
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
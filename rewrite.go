@@ -0,0 +1,100 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/golang/protobuf/proto" // nolint
+)
+
+// Rewrite re-saves the snapshot with the given term and index with the current
+// compression, checksum and encryption settings, e.g. to migrate a snapshot
+// directory in place after changing them. A delta snapshot is rewritten as a
+// full one, and a gzipped snap file as a regular one. The rewritten file must
+// load back to the same snapshot before the original is discarded; otherwise
+// the original is restored and an error is returned. A missing snapshot is
+// reported with an error wrapping ErrNoSnapshot, a corrupt one with a
+// *CorruptSnapshotError.
+func (s *Snapshotter) Rewrite(term, index uint64) error {
+	s.mu.Lock()
+	defer s.unlock()
+
+	// the snap file may be staged by WithSyncBatch, and so is its rewrite
+	if err := s.flush(); err != nil {
+		return err
+	}
+	name := s.snapName(term, index)
+	orig := name
+	b, err := s.readFile(orig)
+	if os.IsNotExist(err) {
+		orig = name + gzSuffix
+		b, err = s.readFile(orig)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: term %d, index %d", ErrNoSnapshot, term, index)
+		}
+		return err
+	}
+	opath := filepath.Join(s.dir, orig)
+	snap, _, err := s.decodeSnap(opath, b)
+	if err != nil {
+		return &CorruptSnapshotError{Path: opath, Err: err}
+	}
+	// re-saving the snapshot under other metadata would leave a new file
+	// beside the original
+	if m := snap.Metadata; m == nil || m.Term != term || m.Index != index {
+		err = fmt.Errorf("snap: snapshot metadata %v does not match term %d, index %d", snap.Metadata, term, index)
+		return &CorruptSnapshotError{Path: opath, Err: err}
+	}
+
+	// restore puts the original back after a failed rewrite in its place.
+	restore := func() {
+		if orig != name {
+			return
+		}
+		if rerr := s.writeFile(name, b); rerr != nil {
+			s.lg.Error().Err(rerr).Str("path", opath).Msg("failed to restore the original snap file after a failed rewrite")
+		}
+	}
+	_, err = s.writeSnapLocked(context.Background(), snap, nil, s.clock.Now())
+	if err == nil {
+		err = s.flush()
+	}
+	if err != nil {
+		restore()
+		return err
+	}
+	g, err := s.readSnap(name)
+	if err == nil && !proto.Equal(g, snap) {
+		err = fmt.Errorf("snap: rewritten snapshot at term %d, index %d differs from the original", term, index)
+	}
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", opath).Msg("rewritten snap file does not load back; restoring the original")
+		restore()
+		return err
+	}
+	if orig != name {
+		if err = s.backend.Remove(orig); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	s.lg.Info().Str("path", opath).Msg("rewrote snap file")
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestRewrite(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = NewSnapshotter(dir).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	ss := NewSnapshotter(dir, WithCompression(ZstdLevel(3)))
+	if err = ss.Rewrite(1, 2); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if err = ss.Rewrite(1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Codec != snappb.Codec_ZSTD {
+		t.Errorf("codec = %v, want %v", saved.Codec, snappb.Codec_ZSTD)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// a snap file whose snapshot does not match its name is not re-saved
+	// under the metadata of the snapshot
+	other := &snappb.Snapshot{Data: []byte("other snapshot"), Metadata: &snappb.SnapshotMetadata{Index: 9, Term: 3}}
+	bufs, _, err := ss.encodeSnap("", other, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 2, 7)), concatBuffers(bufs), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var cerr *CorruptSnapshotError
+	if err = ss.Rewrite(2, 7); !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want a *CorruptSnapshotError", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 3, 9))); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want no snap file for the metadata of the snapshot", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"path/filepath"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// ReadSnapFile reads and verifies the snap file at path, which need not be in
+// a snapshot directory, e.g. to inspect a single file handed to a tool. It
+// decodes the file as a Snapshotter with the default options would: encrypted
+// files cannot be read, and delta snapshots only if their base is next to them.
+// Nothing is renamed or removed, even if the file is corrupt.
+func ReadSnapFile(path string) (*snappb.Snapshot, error) {
+	return NewSnapshotter(filepath.Dir(path)).readSnap(filepath.Base(path))
+}
+
+// WriteSnapFile writes snapshot to a snap file at path, which need not be in a
+// snapshot directory, e.g. to produce test fixtures or a file for manual
+// recovery. The file is encoded as a Snapshotter with the default options would
+// save it, so that it can be loaded once moved to a snapshot directory under
+// its snap file name, and it is written atomically and durably.
+func WriteSnapFile(path string, snapshot *snappb.Snapshot) error {
+	if snapshot.Metadata == nil {
+		return errors.New("snap: snapshot has no metadata")
+	}
+	s := NewSnapshotter(filepath.Dir(path))
+	name := filepath.Base(path)
+	bufs, _, err := s.encodeSnap(name, snapshot, nil, s.clock.Now())
+	if err != nil {
+		return err
+	}
+	if err = s.writeFile(name, bufs...); err != nil {
+		return err
+	}
+	return s.backend.(dirSyncer).SyncDir()
+}
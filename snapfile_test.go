@@ -0,0 +1,98 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+)
+
+func TestReadSnapFile(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = NewSnapshotter(dir).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "0000000000000001-0000000000000001.snap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(dir, "inspect.snap")
+	if err = ioutil.WriteFile(fpath, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadSnapFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	b[len(b)/2] ^= 0xff
+	if err = ioutil.WriteFile(fpath, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	var cerr *CorruptSnapshotError
+	if _, err = ReadSnapFile(fpath); !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want a *CorruptSnapshotError", err)
+	}
+	if !fileutil.Exist(fpath) {
+		t.Errorf("expected %s to be left in place", fpath)
+	}
+}
+
+func TestWriteSnapFile(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fpath := filepath.Join(dir, "fixture.snap")
+	if err = WriteSnapFile(fpath, testSnap); err != nil {
+		t.Fatal(err)
+	}
+	g, err := ReadSnapFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	if err = os.Rename(fpath, filepath.Join(dir, "0000000000000001-0000000000000001.snap")); err != nil {
+		t.Fatal(err)
+	}
+	if g, err = NewSnapshotter(dir).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
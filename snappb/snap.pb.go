@@ -25,6 +25,101 @@ const (
 // of the legacy proto package is being used.
 const _ = proto.ProtoPackageIsVersion4
 
+type Codec int32
+
+const (
+	Codec_NONE Codec = 0
+	Codec_ZSTD Codec = 1
+)
+
+// Enum value maps for Codec.
+var (
+	Codec_name = map[int32]string{
+		0: "NONE",
+		1: "ZSTD",
+	}
+	Codec_value = map[string]int32{
+		"NONE": 0,
+		"ZSTD": 1,
+	}
+)
+
+func (x Codec) Enum() *Codec {
+	p := new(Codec)
+	*p = x
+	return p
+}
+
+func (x Codec) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Codec) Descriptor() protoreflect.EnumDescriptor {
+	return file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_enumTypes[0].Descriptor()
+}
+
+func (Codec) Type() protoreflect.EnumType {
+	return &file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_enumTypes[0]
+}
+
+func (x Codec) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Codec.Descriptor instead.
+func (Codec) EnumDescriptor() ([]byte, []int) {
+	return file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDescGZIP(), []int{0}
+}
+
+type ChecksumAlgo int32
+
+const (
+	ChecksumAlgo_CRC32C   ChecksumAlgo = 0
+	ChecksumAlgo_XXHASH64 ChecksumAlgo = 1
+	ChecksumAlgo_SHA256   ChecksumAlgo = 2
+)
+
+// Enum value maps for ChecksumAlgo.
+var (
+	ChecksumAlgo_name = map[int32]string{
+		0: "CRC32C",
+		1: "XXHASH64",
+		2: "SHA256",
+	}
+	ChecksumAlgo_value = map[string]int32{
+		"CRC32C":   0,
+		"XXHASH64": 1,
+		"SHA256":   2,
+	}
+)
+
+func (x ChecksumAlgo) Enum() *ChecksumAlgo {
+	p := new(ChecksumAlgo)
+	*p = x
+	return p
+}
+
+func (x ChecksumAlgo) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ChecksumAlgo) Descriptor() protoreflect.EnumDescriptor {
+	return file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_enumTypes[1].Descriptor()
+}
+
+func (ChecksumAlgo) Type() protoreflect.EnumType {
+	return &file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_enumTypes[1]
+}
+
+func (x ChecksumAlgo) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ChecksumAlgo.Descriptor instead.
+func (ChecksumAlgo) EnumDescriptor() ([]byte, []int) {
+	return file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDescGZIP(), []int{1}
+}
+
 type WalSnapshot struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -195,8 +290,25 @@ type SavedSnapshot struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Crc  uint32 `protobuf:"varint,1,opt,name=crc,proto3" json:"crc,omitempty"`
-	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Crc   uint32       `protobuf:"varint,1,opt,name=crc,proto3" json:"crc,omitempty"`
+	Data  []byte       `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Codec Codec        `protobuf:"varint,3,opt,name=codec,proto3,enum=snappb.Codec" json:"codec,omitempty"`
+	Algo  ChecksumAlgo `protobuf:"varint,4,opt,name=algo,proto3,enum=snappb.ChecksumAlgo" json:"algo,omitempty"`
+	// checksum holds the checksum of data for algorithms that do not fit in crc.
+	Checksum []byte `protobuf:"bytes,5,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	// created_unix_nanos is the time the snap file was written at.
+	CreatedUnixNanos int64 `protobuf:"varint,6,opt,name=created_unix_nanos,json=createdUnixNanos,proto3" json:"created_unix_nanos,omitempty"`
+	// writer_id identifies the node that wrote the snap file.
+	WriterId string `protobuf:"bytes,7,opt,name=writer_id,json=writerId,proto3" json:"writer_id,omitempty"`
+	// data_len is the length of data, to tell a truncated write from corruption.
+	DataLen uint64 `protobuf:"varint,8,opt,name=data_len,json=dataLen,proto3" json:"data_len,omitempty"`
+	// base_term and base_index identify the snapshot the data of a delta
+	// snapshot applies to. base_index is zero for a full snapshot.
+	BaseTerm  uint64 `protobuf:"varint,9,opt,name=base_term,json=baseTerm,proto3" json:"base_term,omitempty"`
+	BaseIndex uint64 `protobuf:"varint,10,opt,name=base_index,json=baseIndex,proto3" json:"base_index,omitempty"`
+	// crc_poly is the polynomial, in reversed notation, of the crc32 in crc.
+	// Zero stands for the default Castagnoli polynomial.
+	CrcPoly uint32 `protobuf:"varint,11,opt,name=crc_poly,json=crcPoly,proto3" json:"crc_poly,omitempty"`
 }
 
 func (x *SavedSnapshot) Reset() {
@@ -245,6 +357,69 @@ func (x *SavedSnapshot) GetData() []byte {
 	return nil
 }
 
+func (x *SavedSnapshot) GetCodec() Codec {
+	if x != nil {
+		return x.Codec
+	}
+	return Codec_NONE
+}
+
+func (x *SavedSnapshot) GetAlgo() ChecksumAlgo {
+	if x != nil {
+		return x.Algo
+	}
+	return ChecksumAlgo_CRC32C
+}
+
+func (x *SavedSnapshot) GetChecksum() []byte {
+	if x != nil {
+		return x.Checksum
+	}
+	return nil
+}
+
+func (x *SavedSnapshot) GetCreatedUnixNanos() int64 {
+	if x != nil {
+		return x.CreatedUnixNanos
+	}
+	return 0
+}
+
+func (x *SavedSnapshot) GetWriterId() string {
+	if x != nil {
+		return x.WriterId
+	}
+	return ""
+}
+
+func (x *SavedSnapshot) GetDataLen() uint64 {
+	if x != nil {
+		return x.DataLen
+	}
+	return 0
+}
+
+func (x *SavedSnapshot) GetBaseTerm() uint64 {
+	if x != nil {
+		return x.BaseTerm
+	}
+	return 0
+}
+
+func (x *SavedSnapshot) GetBaseIndex() uint64 {
+	if x != nil {
+		return x.BaseIndex
+	}
+	return 0
+}
+
+func (x *SavedSnapshot) GetCrcPoly() uint32 {
+	if x != nil {
+		return x.CrcPoly
+	}
+	return 0
+}
+
 var File_github_com_amazingchow_photon_dance_snap_snappb_snap_proto protoreflect.FileDescriptor
 
 var file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDesc = []byte{
@@ -265,14 +440,37 @@ var file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDesc = []
 	0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e,
 	0x73, 0x6e, 0x61, 0x70, 0x70, 0x62, 0x2e, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x4d,
 	0x65, 0x74, 0x61, 0x64, 0x61, 0x74, 0x61, 0x52, 0x08, 0x6d, 0x65, 0x74, 0x61, 0x64, 0x61, 0x74,
-	0x61, 0x22, 0x35, 0x0a, 0x0d, 0x53, 0x61, 0x76, 0x65, 0x64, 0x53, 0x6e, 0x61, 0x70, 0x73, 0x68,
-	0x6f, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x63, 0x72, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
-	0x03, 0x63, 0x72, 0x63, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68,
-	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x61, 0x6d, 0x61, 0x7a, 0x69, 0x6e, 0x67, 0x63, 0x68,
-	0x6f, 0x77, 0x2f, 0x70, 0x68, 0x6f, 0x74, 0x6f, 0x6e, 0x2d, 0x64, 0x61, 0x6e, 0x63, 0x65, 0x2d,
-	0x73, 0x6e, 0x61, 0x70, 0x2f, 0x73, 0x6e, 0x61, 0x70, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x33,
+	0x61, 0x22, 0xdd, 0x02, 0x0a, 0x0d, 0x53, 0x61, 0x76, 0x65, 0x64, 0x53, 0x6e, 0x61, 0x70, 0x73,
+	0x68, 0x6f, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x63, 0x72, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x03, 0x63, 0x72, 0x63, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x23, 0x0a, 0x05, 0x63, 0x6f, 0x64,
+	0x65, 0x63, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e, 0x73, 0x6e, 0x61, 0x70, 0x70,
+	0x62, 0x2e, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x52, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x28,
+	0x0a, 0x04, 0x61, 0x6c, 0x67, 0x6f, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x14, 0x2e, 0x73,
+	0x6e, 0x61, 0x70, 0x70, 0x62, 0x2e, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x41, 0x6c,
+	0x67, 0x6f, 0x52, 0x04, 0x61, 0x6c, 0x67, 0x6f, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68, 0x65, 0x63,
+	0x6b, 0x73, 0x75, 0x6d, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x63, 0x68, 0x65, 0x63,
+	0x6b, 0x73, 0x75, 0x6d, 0x12, 0x2c, 0x0a, 0x12, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x5f,
+	0x75, 0x6e, 0x69, 0x78, 0x5f, 0x6e, 0x61, 0x6e, 0x6f, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x10, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x64, 0x55, 0x6e, 0x69, 0x78, 0x4e, 0x61, 0x6e,
+	0x6f, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x77, 0x72, 0x69, 0x74, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x77, 0x72, 0x69, 0x74, 0x65, 0x72, 0x49, 0x64, 0x12,
+	0x19, 0x0a, 0x08, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x6c, 0x65, 0x6e, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x07, 0x64, 0x61, 0x74, 0x61, 0x4c, 0x65, 0x6e, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x61,
+	0x73, 0x65, 0x5f, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x09, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x62,
+	0x61, 0x73, 0x65, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x1d, 0x0a, 0x0a, 0x62, 0x61, 0x73, 0x65, 0x5f,
+	0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x04, 0x52, 0x09, 0x62, 0x61, 0x73,
+	0x65, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x72, 0x63, 0x5f, 0x70, 0x6f,
+	0x6c, 0x79, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x63, 0x72, 0x63, 0x50, 0x6f, 0x6c,
+	0x79, 0x2a, 0x1b, 0x0a, 0x05, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f,
+	0x4e, 0x45, 0x10, 0x00, 0x12, 0x08, 0x0a, 0x04, 0x5a, 0x53, 0x54, 0x44, 0x10, 0x01, 0x2a, 0x34,
+	0x0a, 0x0c, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x41, 0x6c, 0x67, 0x6f, 0x12, 0x0a,
+	0x0a, 0x06, 0x43, 0x52, 0x43, 0x33, 0x32, 0x43, 0x10, 0x00, 0x12, 0x0c, 0x0a, 0x08, 0x58, 0x58,
+	0x48, 0x41, 0x53, 0x48, 0x36, 0x34, 0x10, 0x01, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x48, 0x41, 0x32,
+	0x35, 0x36, 0x10, 0x02, 0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63,
+	0x6f, 0x6d, 0x2f, 0x61, 0x6d, 0x61, 0x7a, 0x69, 0x6e, 0x67, 0x63, 0x68, 0x6f, 0x77, 0x2f, 0x70,
+	0x68, 0x6f, 0x74, 0x6f, 0x6e, 0x2d, 0x64, 0x61, 0x6e, 0x63, 0x65, 0x2d, 0x73, 0x6e, 0x61, 0x70,
+	0x2f, 0x73, 0x6e, 0x61, 0x70, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -287,20 +485,25 @@ func file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDescGZIP
 	return file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDescData
 }
 
+var file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
 var file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_goTypes = []interface{}{
-	(*WalSnapshot)(nil),      // 0: snappb.WalSnapshot
-	(*SnapshotMetadata)(nil), // 1: snappb.SnapshotMetadata
-	(*Snapshot)(nil),         // 2: snappb.Snapshot
-	(*SavedSnapshot)(nil),    // 3: snappb.SavedSnapshot
+	(Codec)(0),               // 0: snappb.Codec
+	(ChecksumAlgo)(0),        // 1: snappb.ChecksumAlgo
+	(*WalSnapshot)(nil),      // 2: snappb.WalSnapshot
+	(*SnapshotMetadata)(nil), // 3: snappb.SnapshotMetadata
+	(*Snapshot)(nil),         // 4: snappb.Snapshot
+	(*SavedSnapshot)(nil),    // 5: snappb.SavedSnapshot
 }
 var file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_depIdxs = []int32{
-	1, // 0: snappb.Snapshot.metadata:type_name -> snappb.SnapshotMetadata
-	1, // [1:1] is the sub-list for method output_type
-	1, // [1:1] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	3, // 0: snappb.Snapshot.metadata:type_name -> snappb.SnapshotMetadata
+	0, // 1: snappb.SavedSnapshot.codec:type_name -> snappb.Codec
+	1, // 2: snappb.SavedSnapshot.algo:type_name -> snappb.ChecksumAlgo
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_init() }
@@ -363,13 +566,14 @@ func file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_rawDesc,
-			NumEnums:      0,
+			NumEnums:      2,
 			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_goTypes,
 		DependencyIndexes: file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_depIdxs,
+		EnumInfos:         file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_enumTypes,
 		MessageInfos:      file_github_com_amazingchow_photon_dance_snap_snappb_snap_proto_msgTypes,
 	}.Build()
 	File_github_com_amazingchow_photon_dance_snap_snappb_snap_proto = out.File
@@ -15,29 +15,64 @@
 package snap
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"hash/crc32"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto" // nolint
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
-	pioutil "github.com/amazingchow/photon-dance-snap/ioutil"
 	"github.com/amazingchow/photon-dance-snap/snappb"
 )
 
 var (
-	ErrNoSnapshot    = errors.New("snap: no available snapshot")
-	ErrEmptySnapshot = errors.New("snap: empty snapshot")
-	ErrCRCMismatch   = errors.New("snap: crc mismatch")
-	crcTable         = crc32.MakeTable(crc32.Castagnoli)
+	ErrNoSnapshot           = errors.New("snap: no available snapshot")
+	ErrNoSnapshotDir        = errors.New("snap: snapshot directory does not exist")
+	ErrNoMatchingSnapshot   = error(&noMatchingSnapshotError{"snap: no snapshot matches"})
+	ErrEmptySnapshot        = errors.New("snap: empty snapshot")
+	ErrEmptyFile            = error(&emptySnapshotError{"snap: empty snap file"})
+	ErrEmptyData            = error(&emptySnapshotError{"snap: empty snapshot data"})
+	ErrCRCMismatch          = errors.New("snap: crc mismatch")
+	ErrUnsupportedCodec     = errors.New("snap: unsupported compression codec")
+	ErrUnsupportedChecksum  = errors.New("snap: unsupported checksum algorithm")
+	ErrSnapshotNotFound     = errors.New("snap: snapshot not found")
+	ErrDecryptionFailed     = errors.New("snap: failed to decrypt snapshot")
+	ErrSnapshotExists       = errors.New("snap: snapshot already exists")
+	ErrTruncatedSnapshot    = errors.New("snap: truncated snapshot")
+	ErrDeltaBaseNotFound    = errors.New("snap: base snapshot of delta not found")
+	ErrWriteTimeout         = errors.New("snap: timed out writing snapshot")
+	ErrEnvelopeCorrupt      = errors.New("snap: snap file checksum mismatch")
+	ErrSnapshotTooLarge     = errors.New("snap: snapshot too large")
+	ErrInsufficientSpace    = errors.New("snap: insufficient space to save snapshot")
+	ErrNonMonotonicIndex    = errors.New("snap: snapshot index is not greater than the latest")
+	ErrNewerSnapshotCorrupt = errors.New("snap: newer snapshot in the WAL is corrupt")
+	crcTable                = crc32.MakeTable(crc32.Castagnoli)
+
+	// defaultFileMode is used for snap files unless overridden with WithFileMode.
+	defaultFileMode os.FileMode = 0666
+	// defaultDirMode is used by EnsureDir unless overridden with WithDirMode.
+	defaultDirMode os.FileMode = 0700
+
+	// gzSuffix marks snap files gzipped by external tools, which are
+	// gunzipped transparently when read.
+	gzSuffix = ".gz"
+	// defaultExt is the extension of the snap files, see WithExtension.
+	defaultExt = ".snap"
+	// brokenSuffix is appended to the name of the snap files that fail to load.
+	brokenSuffix = ".broken"
 
 	// A map of valid files that can be present in the snap folder.
 	validFiles = map[string]bool{
@@ -45,217 +80,1998 @@ var (
 	}
 )
 
+// Snapshotter saves and loads the snapshots in a snapshot directory. A Snapshotter
+// is safe for concurrent use: operations that create or remove files exclude each
+// other and any concurrent load.
 type Snapshotter struct {
-	dir string
+	// mu is held for writing by operations mutating the directory on purpose
+	// (save, prune, release, delete), and for reading by loads and listings.
+	mu sync.RWMutex
+
+	settings
+
+	// pending holds the snap files staged by WithSyncBatch until batchTimer
+	// flushes them.
+	pending    map[string]bool
+	batchTimer *time.Timer
+	// attempts numbers the writes staged under a name of their own, see
+	// attemptName.
+	attempts uint64
+	// evicted holds the snap files evicted under s.mu, whose OnDelete hooks
+	// run once it is released, see unlock.
+	evicted []string
+	// pins holds the snapshots kept from being pruned, see Pin. It is guarded
+	// by pinMu rather than mu, so that pinning does not wait for a save.
+	pinMu sync.Mutex
+	pins  map[snapKey]int
+}
+
+// settings are the fields of a Snapshotter set by NewSnapshotter and its
+// options, as opposed to its state.
+type settings struct {
+	lg          *zerolog.Logger
+	clock       Clock
+	dir         string
+	backend     Backend
+	compression Compression
+	checksum    snappb.ChecksumAlgo
+	// aead encrypts the snap files when set with WithEncryption.
+	aead cipher.AEAD
+	// writerID is recorded in every snap file written.
+	writerID string
+	// allowZeroCRC accepts snap files without a checksum, see WithAllowZeroCRC.
+	allowZeroCRC bool
+	// ext is the extension of the snap files, see WithExtension.
+	ext string
+	// names formats and parses the snap filenames, see WithNameCodec.
+	names NameCodec
+	// sync fsyncs the snap files when set, see WithSync.
+	sync bool
+	// writeTimeout bounds the write of a snap file when non-zero,
+	// see WithWriteTimeout.
+	writeTimeout time.Duration
+	// batchInterval and batchSize bound how long and how many snap files are
+	// staged in pending before they are flushed, see WithSyncBatch.
+	batchInterval time.Duration
+	batchSize     int
+	// retryAttempts bounds the attempts to write a snap file, which are
+	// retried after retryBackoff if retryIf holds, see WithRetry.
+	retryAttempts int
+	retryBackoff  time.Duration
+	retryIf       func(error) bool
+	// orphanPatterns extends the files removed by cleanupSnapdir,
+	// see WithOrphanPatterns.
+	orphanPatterns []string
+	// validFiles extends the built-in validFiles, see WithValidFiles.
+	validFiles map[string]bool
+	// onSave, onLoad and onDelete are called once a snapshot was saved,
+	// loaded or deleted, see WithOnSave, WithOnLoad and WithOnDelete.
+	onSave, onLoad, onDelete SnapshotHook
+	// onSaveInfo is called once a snapshot was saved, see WithOnSaveInfo.
+	onSaveInfo SaveHook
+	// onOrphan is called once an orphaned file was removed, see WithOnOrphan.
+	onOrphan OrphanHook
+	// maxBytes bounds the size of the snapshot directory when non-zero,
+	// see WithMaxBytes.
+	maxBytes int64
+	// maxSnapshotBytes bounds the size of a single snapshot when non-zero,
+	// see WithMaxSnapshotBytes.
+	maxSnapshotBytes int64
+	// spaceCheck checks that the backend has room for the snap file plus
+	// spaceMargin bytes before writing it, see WithSpaceCheck.
+	spaceCheck  bool
+	spaceMargin int64
+	// fileMode is zero unless set explicitly with WithFileMode,
+	// in which case it is enforced after each write.
+	fileMode os.FileMode
+	dirMode  os.FileMode
+	// brokenRename renames snap files that fail to load to *.broken.
+	brokenRename bool
+	// brokenTimestamped suffixes the *.broken name of snap files that fail to
+	// load with a timestamp, see WithTimestampedBroken.
+	brokenTimestamped bool
+	// overwrite replaces an existing snap file on save, see WithOverwrite.
+	overwrite bool
+	// strictWALMatch rejects snapshots loaded by LoadNewestAvailable that
+	// are not the newest of the WAL, see WithStrictWALMatch.
+	strictWALMatch bool
+	// monotonicIndex rejects saves not newer than the latest snapshot,
+	// see WithMonotonicIndex.
+	monotonicIndex bool
+	// mirror holds a copy of every snap file saved when set, in mirrorDir,
+	// see WithMirror.
+	mirror    Backend
+	mirrorDir string
+	// readOnly disables the renames and removals done while loading,
+	// see WithReadOnly.
+	readOnly bool
+	// crcTable overrides the package crcTable for the crc of new snap files
+	// when set, see WithCRCTable.
+	crcTable *crc32.Table
+	// loadConcurrency bounds how many snap files are decoded at once while loading.
+	loadConcurrency int
+	// payloadValidator rejects loaded snapshots whose data is invalid,
+	// see WithPayloadValidator.
+	payloadValidator func(*snappb.Snapshot) error
+}
+
+func NewSnapshotter(dir string, opts ...SnapshotterOption) *Snapshotter {
+	s := &Snapshotter{settings: settings{
+		lg:              &log.Logger,
+		clock:           realClock{},
+		dir:             dir,
+		backend:         NewFileBackend(dir),
+		dirMode:         defaultDirMode,
+		brokenRename:    true,
+		loadConcurrency: 1,
+		ext:             defaultExt,
+		names:           defaultNameCodec{},
+		sync:            true,
+		overwrite:       true,
+		retryAttempts:   1,
+		retryIf:         isTransientWriteError,
+	}}
+	s.applyOpts(opts)
+	if !s.sync {
+		s.lg.Warn().Str("path", dir).Msg("fsync of snap files is disabled; snapshots are not durable, do not use in production")
+	}
+	return s
 }
 
-func NewSnapshotter(dir string) *Snapshotter {
-	return &Snapshotter{
-		dir: dir,
+// EnsureDir creates the snapshot directory, and the mirror directory if any, if
+// it does not exist yet, so that a fresh node fails upfront rather than on its
+// first save.
+func (s *Snapshotter) EnsureDir() error {
+	for _, be := range []Backend{s.backend, s.mirror} {
+		if dc, ok := be.(dirCreator); ok {
+			if err := dc.MkdirAll(s.dirMode); err != nil {
+				return err
+			}
+		}
 	}
+	return nil
 }
 
 func (s *Snapshotter) SaveSnap(snapshot *snappb.Snapshot) error {
+	return s.SaveSnapContext(context.Background(), snapshot)
+}
+
+// SaveSnapContext is like SaveSnap, but gives up and returns ctx.Err() as soon as
+// ctx is cancelled. A write that is still in flight when ctx is cancelled keeps
+// running in the background and removes the file it wrote once it finishes.
+func (s *Snapshotter) SaveSnapContext(ctx context.Context, snapshot *snappb.Snapshot) error {
 	if snapshot.Metadata == nil || snapshot.Metadata.Index == 0 {
 		return nil
 	}
-	return s.save(snapshot)
+	return s.saveContext(ctx, snapshot)
+}
+
+// SaveSnapN is like SaveSnap, but also returns the size of the snap file
+// written, envelope included, e.g. to track the on-disk size of snapshots
+// without a stat. Zero is returned when nothing was written.
+func (s *Snapshotter) SaveSnapN(snapshot *snappb.Snapshot) (int64, error) {
+	if snapshot.Metadata == nil || snapshot.Metadata.Index == 0 {
+		return 0, nil
+	}
+	return s.saveSnap(context.Background(), snapshot, nil)
 }
 
 func (s *Snapshotter) save(snapshot *snappb.Snapshot) error {
-	start := time.Now()
+	return s.saveContext(context.Background(), snapshot)
+}
 
-	fname := fmt.Sprintf("%016x-%016x.snap", snapshot.Metadata.Term, snapshot.Metadata.Index)
+func (s *Snapshotter) saveContext(ctx context.Context, snapshot *snappb.Snapshot) error {
+	_, err := s.saveSnap(ctx, snapshot, nil)
+	return err
+}
 
-	b, err := proto.Marshal(snapshot)
+// saveSnap saves snapshot, whose data is a delta against the snapshot base
+// unless base is nil, and returns the size of the snap file written.
+func (s *Snapshotter) saveSnap(ctx context.Context, snapshot *snappb.Snapshot, base *snappb.SnapshotMetadata) (int64, error) {
+	start := s.clock.Now()
+	info, err := s.writeSnap(ctx, snapshot, base, start)
 	if err != nil {
-		panic(err)
+		snapSaveTotal.WithLabelValues("error").Inc()
+		snapSaveFailureSec.Observe(s.clock.Now().Sub(start).Seconds())
+		return 0, err
+	}
+	snapSaveTotal.WithLabelValues("success").Inc()
+	s.runHook("OnSave", s.onSave, info.Term, info.Index, info.Path)
+	s.runSaveHook(info)
+	return info.Size, nil
+}
+
+func (s *Snapshotter) writeSnap(ctx context.Context, snapshot *snappb.Snapshot, base *snappb.SnapshotMetadata, start time.Time) (SaveInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return SaveInfo{}, err
+	}
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	fname := s.snapName(snapshot.Metadata.Term, snapshot.Metadata.Index)
+
+	if s.monotonicIndex {
+		term, index, err := s.latest()
+		if err != nil && err != ErrNoSnapshot {
+			return SaveInfo{}, err
+		}
+		// snap files staged by WithSyncBatch are not listed yet
+		if pterm, pindex, ok := s.latestPending(); ok && (err != nil || pindex > index) {
+			term, index, err = pterm, pindex, nil
+		}
+		if err == nil && snapshot.Metadata.Index <= index {
+			return SaveInfo{}, fmt.Errorf("%w: index %d, latest term %d, index %d", ErrNonMonotonicIndex, snapshot.Metadata.Index, term, index)
+		}
+	}
+	if !s.overwrite {
+		for _, name := range []string{fname, fname + gzSuffix} {
+			if _, err := s.backend.Stat(name); err == nil || s.pending[name] {
+				return SaveInfo{}, fmt.Errorf("%w: term %d, index %d", ErrSnapshotExists, snapshot.Metadata.Term, snapshot.Metadata.Index)
+			} else if !os.IsNotExist(err) {
+				return SaveInfo{}, err
+			}
+		}
+	}
+	return s.writeSnapLocked(ctx, snapshot, base, start)
+}
+
+// writeSnapLocked writes the snap file of snapshot, replacing any existing
+// one, without the checks of WithMonotonicIndex and WithOverwrite, as part of
+// a save started at start. s.mu must be held.
+func (s *Snapshotter) writeSnapLocked(ctx context.Context, snapshot *snappb.Snapshot, base *snappb.SnapshotMetadata, start time.Time) (SaveInfo, error) {
+	fname := s.snapName(snapshot.Metadata.Term, snapshot.Metadata.Index)
+
+	// a delta must not be written without its base, or it could never be read
+	if base != nil {
+		if _, err := s.backend.Stat(s.snapName(base.Term, base.Index)); err != nil {
+			if os.IsNotExist(err) {
+				return SaveInfo{}, fmt.Errorf("%w: term %d, index %d", ErrDeltaBaseNotFound, base.Term, base.Index)
+			}
+			return SaveInfo{}, err
+		}
 	}
-	crc := crc32.Update(0, crcTable, b)
-	b, err = proto.Marshal(&snappb.SavedSnapshot{Crc: crc, Data: b})
+
+	bufs, info, err := s.encodeSnap(fname, snapshot, base, start)
 	if err != nil {
-		panic(err)
+		return SaveInfo{}, err
 	}
+	size := buffersLen(bufs)
+	info.Term, info.Index = snapshot.Metadata.Term, snapshot.Metadata.Index
+	info.Path = filepath.Join(s.dir, fname)
+	info.Size = int64(size)
 
 	spath := filepath.Join(s.dir, fname)
 
-	fsyncStart := time.Now()
-	err = pioutil.WriteAndSyncFile(spath, b, 0666)
-	snapFsyncSec.Observe(time.Since(fsyncStart).Seconds())
+	if err = ctx.Err(); err != nil {
+		return SaveInfo{}, err
+	}
+	if err = s.checkSpace(spath, size); err != nil {
+		return SaveInfo{}, err
+	}
+
+	if s.batched() {
+		if err = s.writeBatched(fname, bufs, start); err != nil {
+			return SaveInfo{}, err
+		}
+		snapCompressionRatio.Observe(info.Ratio())
+		return info, nil
+	}
+
+	// A write that may be given up on goes to a file of its own, renamed to
+	// fname once done, so that when it finishes after it was abandoned, it
+	// can neither interleave with nor remove the file of a later attempt.
+	wname := fname
+	if ctx.Done() != nil || s.writeTimeout > 0 {
+		wname = s.attemptName(fname)
+	}
+	fsyncStart := s.clock.Now()
+	// the write may outlive the lock once abandoned, so it keeps to the
+	// backend and directory of the time it was started, which MoveDir may
+	// change in the meantime.
+	be, dir := s.backend, s.dir
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.writeFileWithRetry(be, dir, wname, bufs...)
+	}()
+	// abandon removes the file once the write given up on finishes.
+	abandon := func() {
+		go func() {
+			<-errc
+			if rerr := be.Remove(wname); rerr != nil && !os.IsNotExist(rerr) {
+				s.lg.Warn().Err(rerr).Str("path", filepath.Join(dir, wname)).Msg("failed to remove a cancelled snap file")
+			}
+		}()
+	}
+	var timeout <-chan time.Time
+	if s.writeTimeout > 0 {
+		t := time.NewTimer(s.writeTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		abandon()
+		return SaveInfo{}, ctx.Err()
+	case <-timeout:
+		s.lg.Warn().Str("path", spath).Dur("timeout", s.writeTimeout).Msg("timed out writing a snap file")
+		abandon()
+		return SaveInfo{}, ErrWriteTimeout
+	}
+	if s.sync {
+		snapFsyncSec.Observe(s.clock.Now().Sub(fsyncStart).Seconds())
+	}
 
+	// A failed write leaves nothing under its name, and in particular leaves
+	// the previous snap file of the same name, if any, in place. Only the
+	// file of an abandonable write, once written, is left to remove.
+	if err == nil && wname != fname {
+		if err = s.backend.Rename(wname, fname); err != nil {
+			if rerr := s.backend.Remove(wname); rerr != nil && !os.IsNotExist(rerr) {
+				s.lg.Warn().Err(rerr).Str("path", filepath.Join(s.dir, wname)).Msg("failed to remove a written snap file")
+			}
+		}
+	}
 	if err != nil {
-		log.Warn().Err(err).Str("path", spath).Msg("failed to write a snap file")
-		rerr := os.Remove(spath)
-		if rerr != nil {
-			log.Warn().Err(err).Str("path", spath).Msg("failed to remove a broken snap file")
+		s.lg.Warn().Err(err).Str("path", spath).Msg("failed to write a snap file")
+		return SaveInfo{}, err
+	}
+
+	// The snap file itself is durable at this point, a failure to sync the
+	// directory only puts its entry at risk, hence it does not fail the save.
+	if ds, ok := s.backend.(dirSyncer); ok && s.sync {
+		dirFsyncStart := s.clock.Now()
+		if err = ds.SyncDir(); err != nil {
+			s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to fsync the snapshot directory")
 		}
-		return err
+		snapDirFsyncSec.Observe(s.clock.Now().Sub(dirFsyncStart).Seconds())
+	}
+
+	if s.mirror != nil {
+		s.writeMirror(fname, bufs...)
+	}
+
+	if s.maxBytes > 0 {
+		s.evict(fname)
+	}
+
+	snapSaveSec.Observe(s.clock.Now().Sub(start).Seconds())
+	snapCompressionRatio.Observe(info.Ratio())
+	return info, nil
+}
+
+// encodeSnap encodes snapshot, whose data is a delta against the snapshot base
+// unless base is nil, into the content of its snap file fname, created at start.
+// The content is returned as the buffers it is made of, to be written one after
+// the other, see encodeSnapStreamed, along with the sizes of the payload before
+// and after compression.
+func (s *Snapshotter) encodeSnap(fname string, snapshot *snappb.Snapshot, base *snappb.SnapshotMetadata, start time.Time) ([][]byte, SaveInfo, error) {
+	if n := proto.Size(snapshot); s.maxSnapshotBytes > 0 && int64(n) > s.maxSnapshotBytes {
+		s.lg.Warn().Int("size", n).Int64("max-size", s.maxSnapshotBytes).Str("path", filepath.Join(s.dir, fname)).
+			Msg("refusing to save an oversized snapshot")
+		return nil, SaveInfo{}, fmt.Errorf("%w: %d bytes, at most %d allowed", ErrSnapshotTooLarge, n, s.maxSnapshotBytes)
+	}
+	if s.compression.codec == snappb.Codec_NONE && s.aead == nil {
+		return s.encodeSnapStreamed(snapshot, base, start)
+	}
+
+	// The snapshot is staged in pooled buffers until it is marshaled into the
+	// envelope, or the envelope encrypted, which copy it.
+	marshaled, compressed := getBuf(), getBuf()
+	defer putBuf(marshaled)
+	defer putBuf(compressed)
+	marshalTo(marshaled, snapshot)
+	// The crc is computed over the compressed bytes that are actually persisted,
+	// so that corruption on disk is still detected before decompressing.
+	b, err := compress(s.compression, *marshaled, (*compressed)[:0])
+	if s.compression.codec != snappb.Codec_NONE {
+		*compressed = b
+	}
+	if err != nil {
+		s.lg.Warn().Err(err).Msg("failed to compress snapshot data")
+		return nil, SaveInfo{}, err
+	}
+	table, poly := s.writeCRCTable()
+	crc, sum, err := computeChecksum(s.checksum, table, b)
+	if err != nil {
+		s.lg.Warn().Err(err).Str("algo", s.checksum.String()).Msg("failed to compute snapshot checksum")
+		return nil, SaveInfo{}, err
+	}
+	info := SaveInfo{UncompressedBytes: int64(len(*marshaled)), CompressedBytes: int64(len(b))}
+	saved := &snappb.SavedSnapshot{
+		Crc:              crc,
+		Data:             b,
+		Codec:            s.compression.codec,
+		Algo:             s.checksum,
+		Checksum:         sum,
+		CreatedUnixNanos: start.UnixNano(),
+		WriterId:         s.writerID,
+		DataLen:          uint64(len(b)),
+		BaseTerm:         base.GetTerm(),
+		BaseIndex:        base.GetIndex(),
+		CrcPoly:          poly,
+	}
+	if s.aead == nil {
+		if b, err = proto.Marshal(saved); err != nil {
+			panic(err)
+		}
+		return [][]byte{appendTrailer(b)}, info, nil
 	}
+	envelope := getBuf()
+	defer putBuf(envelope)
+	marshalTo(envelope, saved)
+	if b, err = encrypt(s.aead, *envelope); err != nil {
+		s.lg.Warn().Err(err).Msg("failed to encrypt snapshot")
+		return nil, SaveInfo{}, err
+	}
+	return [][]byte{appendTrailer(b)}, info, nil
+}
 
-	snapSaveSec.Observe(time.Since(start).Seconds())
+// checkSpace fails with ErrInsufficientSpace when the backend is known not to
+// have room for the size bytes of the snap file spath, plus the margin set with
+// WithSpaceCheck. A failure to tell the available space does not fail the save.
+func (s *Snapshotter) checkSpace(spath string, size int) error {
+	sc, ok := s.backend.(spaceChecker)
+	if !s.spaceCheck || !ok {
+		return nil
+	}
+	avail, err := sc.AvailableSpace()
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to get the available space; skipping space check")
+		return nil
+	}
+	need := uint64(size)
+	if s.spaceMargin > 0 {
+		need += uint64(s.spaceMargin)
+	}
+	if avail < need {
+		s.lg.Warn().Str("path", spath).Uint64("available", avail).Uint64("needed", need).
+			Msg("refusing to save a snapshot; not enough space")
+		return fmt.Errorf("%w: %d bytes needed, %d available", ErrInsufficientSpace, need, avail)
+	}
 	return nil
 }
 
-func (s *Snapshotter) Load() (*snappb.Snapshot, error) {
-	return s.loadMatched(func(*snappb.Snapshot) bool { return true })
+// writeFile writes data to the named file with the configured permissions.
+// The data may be split in several buffers, which are written one after the
+// other, without being concatenated first if the backend supports it.
+func (s *Snapshotter) writeFile(name string, data ...[]byte) error {
+	return s.writeFileTo(s.backend, name, data...)
 }
 
-func (s *Snapshotter) LoadNewestAvailable(walSnaps []snappb.WalSnapshot) (*snappb.Snapshot, error) {
-	return s.loadMatched(func(snapshot *snappb.Snapshot) bool {
-		m := snapshot.Metadata
-		for i := len(walSnaps) - 1; i >= 0; i-- {
-			if m.Term == walSnaps[i].Term && m.Index == walSnaps[i].Index {
-				return true
-			}
+// attemptName returns a name of its own to write the snap file fname to before
+// it is renamed to fname, "<name>.<attempt><ext>.tmp", which is cleaned up as
+// an orphan if the write never completes. It is called with s.mu held.
+func (s *Snapshotter) attemptName(fname string) string {
+	s.attempts++
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(fname, s.ext), s.attempts, s.ext+tmpSuffix)
+}
+
+// writeFileTo is like writeFile, but writes to the backend be.
+func (s *Snapshotter) writeFileTo(be Backend, name string, data ...[]byte) error {
+	write := func(name string, data [][]byte, perm os.FileMode) error {
+		return be.Write(name, concatBuffers(data), perm)
+	}
+	if uw, ok := be.(unsyncedWriter); ok && !s.sync {
+		write = func(name string, data [][]byte, perm os.FileMode) error {
+			return uw.WriteUnsynced(name, concatBuffers(data), perm)
 		}
-		return false
-	})
+	} else if vw, ok := be.(vectorWriter); ok {
+		write = vw.WriteBuffers
+	}
+	if s.fileMode == 0 {
+		return write(name, data, defaultFileMode)
+	}
+	if err := write(name, data, s.fileMode); err != nil {
+		return err
+	}
+	cm, ok := be.(chmodder)
+	if !ok {
+		return nil
+	}
+	fi, err := be.Stat(name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm() != s.fileMode.Perm() {
+		return cm.Chmod(name, s.fileMode)
+	}
+	return nil
 }
 
-func (s *Snapshotter) loadMatched(matchFn func(*snappb.Snapshot) bool) (*snappb.Snapshot, error) {
-	names, err := s.snapnames()
+// Load returns the newest valid snapshot of the snapshot directory. It fails
+// with ErrNoSnapshot if there is none, and with an error matching
+// ErrNoSnapshotDir, as well as os.ErrNotExist, if the snapshot directory does
+// not exist, e.g. on a fresh node.
+func (s *Snapshotter) Load() (*snappb.Snapshot, error) {
+	return s.LoadContext(context.Background())
+}
+
+// LoadContext is like Load, but stops walking the snapshot directory and returns
+// ctx.Err() once ctx is cancelled.
+func (s *Snapshotter) LoadContext(ctx context.Context) (*snappb.Snapshot, error) {
+	ls, _, err := s.loadMatched(ctx, func(*snappb.Snapshot) bool { return true })
 	if err != nil {
 		return nil, err
 	}
-	var snap *snappb.Snapshot
-	for _, name := range names {
-		if snap, err = loadSnap(s.dir, name); err == nil && matchFn(snap) {
-			return snap, nil
-		}
-	}
-	return nil, ErrNoSnapshot
+	return ls.snap, nil
 }
 
-func loadSnap(dir, name string) (*snappb.Snapshot, error) {
-	fpath := filepath.Join(dir, name)
-	snap, err := readSnap(fpath)
+// LoadOldest is like Load, but returns the oldest valid snapshot instead of
+// the newest one.
+func (s *Snapshotter) LoadOldest() (*snappb.Snapshot, error) {
+	ls, _, err := s.loadMatchedInOrder(context.Background(), true, func(*snappb.Snapshot) bool { return true })
 	if err != nil {
-		log.Warn().Err(err).Str("path", fpath).Msg("failed to read a snap file")
-		brokenPath := fpath + ".broken"
-		if rerr := os.Rename(fpath, brokenPath); rerr != nil {
-			log.Warn().Err(err).Str("path", fpath).Str("broken-path", brokenPath).Msg("failed to rename a broken snap file")
-		} else {
-			log.Warn().Err(err).Str("path", fpath).Str("broken-path", brokenPath).Msg("renamed to a broken snap file")
+		return nil, err
+	}
+	return ls.snap, nil
+}
+
+// LoadOrInit is like Load, but returns an empty snapshot, with zero term and
+// index, when there is no snapshot yet, e.g. on a new node, including when the
+// snapshot directory does not exist. A zero index means "no snapshot yet", it
+// is never the index of a saved snapshot. Snap files that all fail to load are
+// still reported as an error, so that a node does not silently start from
+// scratch over a corrupt snapshot directory. This holds on later calls as
+// well, for as long as the snap files renamed to *.broken are kept and no
+// valid snapshot has been saved since.
+func (s *Snapshotter) LoadOrInit() (*snappb.Snapshot, error) {
+	snap, err := s.Load()
+	if err == ErrNoSnapshot {
+		if name, ok := s.brokenSnapName(); ok {
+			return nil, &noSnapshotError{err: &CorruptSnapshotError{
+				Path: filepath.Join(s.dir, name),
+				Err:  errors.New("snap: snap file failed to load before"),
+			}}
 		}
 	}
+	if err == ErrNoSnapshot || errors.Is(err, ErrNoSnapshotDir) {
+		return &snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{}}, nil
+	}
 	return snap, err
 }
 
-func readSnap(snapname string) (*snappb.Snapshot, error) {
-	b, err := ioutil.ReadFile(snapname)
+// brokenSnapName returns the name of a snap file renamed to *.broken in the
+// snapshot directory, if any.
+func (s *Snapshotter) brokenSnapName() (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.backend.List()
 	if err != nil {
-		log.Warn().Err(err).Str("path", snapname).Msg("failed to read a snap file")
-		return nil, err
+		return "", false
 	}
-	if len(b) == 0 {
-		log.Warn().Str("path", snapname).Msg("failed to read empty snap file")
-		return nil, ErrEmptySnapshot
+	for _, name := range names {
+		if orig, ok := trimBrokenSuffix(name); ok && s.isSnapName(orig) {
+			return name, true
+		}
 	}
+	return "", false
+}
 
-	var serializedSnap snappb.SavedSnapshot
-	if err = proto.Unmarshal(b, &serializedSnap); err != nil {
-		log.Warn().Str("path", snapname).Msg("failed to unmarshal snappb.SavedSnapshot")
-		return nil, err
+// LoadWithPath is like Load, but also returns the absolute path of the snap
+// file the snapshot was loaded from.
+func (s *Snapshotter) LoadWithPath() (*snappb.Snapshot, string, error) {
+	ls, _, err := s.loadMatched(context.Background(), func(*snappb.Snapshot) bool { return true })
+	if err != nil {
+		return nil, "", err
 	}
-	if len(serializedSnap.Data) == 0 || serializedSnap.Crc == 0 {
-		log.Warn().Str("path", snapname).Msg("failed to read empty snapshot data")
-		return nil, ErrEmptySnapshot
+	fpath, err := filepath.Abs(filepath.Join(s.dir, ls.name))
+	if err != nil {
+		return nil, "", err
 	}
+	return ls.snap, fpath, nil
+}
 
-	crc := crc32.Update(0, crcTable, serializedSnap.Data)
-	if crc != serializedSnap.Crc {
-		log.Warn().Str("path", snapname).Uint32("prev-crc", serializedSnap.Crc).Uint32("new-crc", crc).Msg("snap file is corrupt")
-		return nil, ErrCRCMismatch
+// LoadWithInfo is like Load, but also describes the snap file the snapshot
+// was loaded from, including when and by which writer it was written.
+func (s *Snapshotter) LoadWithInfo() (*snappb.Snapshot, *SnapInfo, error) {
+	ls, _, err := s.loadMatched(context.Background(), func(*snappb.Snapshot) bool { return true })
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := s.snapInfo(ls.name, ls.saved)
+	if err != nil {
+		return nil, nil, err
 	}
+	return ls.snap, info, nil
+}
 
-	var snap snappb.Snapshot
-	if err = proto.Unmarshal(serializedSnap.Data, &snap); err != nil {
-		log.Warn().Str("path", snapname).Msg("failed to unmarshal snappb.Snapshot")
-		return nil, err
+// LoadNewestAvailable returns the newest valid snapshot that is also one of
+// walSnaps, e.g. the snapshots recorded in the WAL. It fails with
+// ErrNoMatchingSnapshot, which also matches ErrNoSnapshot, if there are valid
+// snapshots but none of walSnaps.
+func (s *Snapshotter) LoadNewestAvailable(walSnaps []snappb.WalSnapshot) (*snappb.Snapshot, error) {
+	snap, _, err := s.LoadNewestAvailableWithResult(walSnaps)
+	return snap, err
+}
+
+// LoadNewestAvailableWithResult is like LoadNewestAvailable, but also reports
+// how many snap files were skipped as corrupt and how many did not match
+// walSnaps, to tell why no snapshot could be loaded.
+func (s *Snapshotter) LoadNewestAvailableWithResult(walSnaps []snappb.WalSnapshot) (*snappb.Snapshot, LoadResult, error) {
+	ls, result, err := s.loadMatched(context.Background(), func(snapshot *snappb.Snapshot) bool {
+		m := snapshot.Metadata
+		for i := len(walSnaps) - 1; i >= 0; i-- {
+			if m.Term == walSnaps[i].Term && m.Index == walSnaps[i].Index {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, result, err
+	}
+	if s.strictWALMatch {
+		if err = s.checkNewerWALSnaps(ls.snap.Metadata, walSnaps); err != nil {
+			return nil, result, err
+		}
 	}
-	return &snap, nil
+	return ls.snap, result, nil
 }
 
-func (s *Snapshotter) snapnames() ([]string, error) {
-	dir, err := os.Open(s.dir)
+// checkNewerWALSnaps fails with ErrNewerSnapshotCorrupt if the snap file of a
+// snapshot of walSnaps newer than the loaded one, with metadata m, is on disk,
+// since it failed to load then. The snap files of newer snapshots that are
+// missing altogether are only logged.
+func (s *Snapshotter) checkNewerWALSnaps(m *snappb.SnapshotMetadata, walSnaps []snappb.WalSnapshot) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filenames, err := s.backend.List()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	// broken maps the names of snap files, compressed or not, to the broken
+	// files they were renamed to
+	broken := make(map[string][]string)
+	for _, fname := range filenames {
+		if orig, ok := trimBrokenSuffix(fname); ok {
+			orig = strings.TrimSuffix(orig, gzSuffix)
+			broken[orig] = append(broken[orig], fname)
+		}
+	}
+	for i := range walSnaps {
+		ws := &walSnaps[i]
+		if ws.Index < m.Index || (ws.Index == m.Index && ws.Term <= m.Term) {
+			continue
+		}
+		name := s.snapName(ws.Term, ws.Index)
+		for _, candidate := range append([]string{name, name + gzSuffix}, broken[name]...) {
+			if _, err := s.backend.Stat(candidate); err != nil {
+				continue
+			}
+			fpath := filepath.Join(s.dir, candidate)
+			s.lg.Warn().Str("path", fpath).Uint64("term", ws.Term).Uint64("index", ws.Index).Uint64("loaded-index", m.Index).
+				Msg("newer snapshot in the WAL is corrupt; refusing to load a stale snapshot")
+			return fmt.Errorf("%w: %s (term %d, index %d)", ErrNewerSnapshotCorrupt, fpath, ws.Term, ws.Index)
+		}
+		s.lg.Warn().Str("path", filepath.Join(s.dir, name)).Uint64("term", ws.Term).Uint64("index", ws.Index).
+			Msg("newer snapshot in the WAL has no snap file")
+	}
+	return nil
+}
+
+// LoadNewestAvailableOrLatest is like LoadNewestAvailable, but when none of the
+// snapshots match walSnaps, falls back to the newest valid snapshot, as a last
+// resort to recover from. The returned bool reports whether the fallback was
+// used, in which case the snapshot may be inconsistent with the WAL.
+func (s *Snapshotter) LoadNewestAvailableOrLatest(walSnaps []snappb.WalSnapshot) (*snappb.Snapshot, bool, error) {
+	snap, _, err := s.LoadNewestAvailableWithResult(walSnaps)
+	if err != ErrNoMatchingSnapshot {
+		return snap, false, err
 	}
-	defer dir.Close()
-	filenames, err := dir.Readdirnames(-1)
+	if snap, err = s.Load(); err != nil {
+		return nil, false, err
+	}
+	s.lg.Warn().Str("path", s.dir).Uint64("term", snap.Metadata.Term).Uint64("index", snap.Metadata.Index).
+		Msg("no snapshot matches the WAL; falling back to the newest snapshot")
+	return snap, true, nil
+}
+
+// LoadByIndex loads the snapshot at exactly the given index. Should several
+// terms share the index, the snapshot with the highest term is returned. It
+// fails with ErrNoMatchingSnapshot, which also matches ErrNoSnapshot, if no
+// valid snapshot has the index.
+func (s *Snapshotter) LoadByIndex(index uint64) (*snappb.Snapshot, error) {
+	ls, _, err := s.loadMatched(context.Background(), func(snapshot *snappb.Snapshot) bool {
+		return snapshot.Metadata.Index == index
+	})
 	if err != nil {
 		return nil, err
 	}
-	filenames, err = s.cleanupSnapdir(filenames)
+	return ls.snap, nil
+}
+
+// LoadRecent loads up to n of the newest valid snapshots, newest first. Snap
+// files that fail to load are skipped (and renamed to *.broken, unless disabled
+// with WithBrokenRename). It returns ErrNoSnapshot only if no snapshot is valid.
+func (s *Snapshotter) LoadRecent(n int) ([]*snappb.Snapshot, error) {
+	snaps, names, err := s.loadRecent(n)
 	if err != nil {
 		return nil, err
 	}
-	snaps := checkSuffix(filenames)
-	if len(snaps) == 0 {
-		return nil, ErrNoSnapshot
+	for i, snap := range snaps {
+		m := snap.Metadata
+		s.runHook("OnLoad", s.onLoad, m.GetTerm(), m.GetIndex(), filepath.Join(s.dir, names[i]))
 	}
-	sort.Sort(sort.Reverse(sort.StringSlice(snaps)))
 	return snaps, nil
 }
 
-func checkSuffix(filenames []string) []string {
-	snaps := []string{}
-	for i := range filenames {
-		if strings.HasSuffix(filenames[i], ".snap") {
-			snaps = append(snaps, filenames[i])
-		} else {
-			// If we find a file which is not a snapshot then check if it's
-			// a vaild file. If not throw out a warning.
-			if _, ok := validFiles[filenames[i]]; !ok {
-				log.Warn().Str("path", filenames[i]).Msg("found unexpected non-snap file; skipping")
+func (s *Snapshotter) loadRecent(n int) (snaps []*snappb.Snapshot, loaded []string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.loadnames()
+	if err != nil {
+		return nil, nil, err
+	}
+	var corruptErr error
+	for _, name := range names {
+		if len(snaps) >= n {
+			break
+		}
+		snap, _, err := s.loadSnap(name)
+		if err != nil {
+			if corruptErr == nil {
+				corruptErr = err
 			}
+			continue
 		}
+		snaps = append(snaps, snap)
+		loaded = append(loaded, name)
 	}
-	return snaps
+	if len(snaps) == 0 && n > 0 {
+		if corruptErr != nil {
+			return nil, nil, &noSnapshotError{err: corruptErr}
+		}
+		return nil, nil, ErrNoSnapshot
+	}
+	return snaps, loaded, nil
 }
 
-// cleanupSnapdir removes any files that should not be in the snapshot directory:
-// - db.tmp prefixed files that can be orphaned by defragmentation
-func (s *Snapshotter) cleanupSnapdir(filenames []string) (names []string, err error) {
-	names = make([]string, 0, len(filenames))
-	for _, filename := range filenames {
-		if strings.HasPrefix(filename, "db.tmp") {
-			log.Info().Str("path", filename).Msg("found orphaned defragmentation file; deleting")
-			if rerr := os.Remove(filepath.Join(s.dir, filename)); rerr != nil && !os.IsNotExist(rerr) {
-				return names, fmt.Errorf("failed to remove orphaned .snap.db file %s: %v", filename, rerr)
-			}
-		} else {
-			names = append(names, filename)
+// LoadRange loads the valid snapshots whose index is within [lo, hi], newest
+// first. The indexes are parsed from the file names, so only the files in the
+// range are read. Files that fail to load are skipped, and an empty slice is
+// returned when no snapshot is in the range.
+func (s *Snapshotter) LoadRange(lo, hi uint64) ([]*snappb.Snapshot, error) {
+	snaps, names, err := s.loadRange(lo, hi)
+	if err != nil {
+		return nil, err
+	}
+	for i, snap := range snaps {
+		m := snap.Metadata
+		s.runHook("OnLoad", s.onLoad, m.GetTerm(), m.GetIndex(), filepath.Join(s.dir, names[i]))
+	}
+	return snaps, nil
+}
+
+func (s *Snapshotter) loadRange(lo, hi uint64) (snaps []*snappb.Snapshot, loaded []string, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snaps = []*snappb.Snapshot{}
+	names, err := s.loadnames()
+	if err != nil {
+		if err == ErrNoSnapshot {
+			return snaps, nil, nil
 		}
+		return nil, nil, err
 	}
-	return names, nil
+	for _, name := range names {
+		_, index, perr := s.parseSnapName(name)
+		if perr != nil || index < lo || index > hi {
+			continue
+		}
+		snap, _, err := s.loadSnap(name)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snap)
+		loaded = append(loaded, name)
+	}
+	return snaps, loaded, nil
 }
 
-func (s *Snapshotter) ReleaseSnapDBs(snap *snappb.Snapshot) error {
-	dir, err := os.Open(s.dir)
+// loadedSnap is a snapshot loaded from the snap file name, with the
+// metadata of its envelope (without the data).
+type loadedSnap struct {
+	name  string
+	snap  *snappb.Snapshot
+	saved *snappb.SavedSnapshot
+}
+
+// LoadResult tells how the snap files were examined while looking for a
+// snapshot to load, e.g. to find out why no snapshot could be loaded.
+type LoadResult struct {
+	// Matched is true if a snapshot was loaded.
+	Matched bool
+	// CorruptCount is the number of snap files skipped because they could
+	// not be read or failed verification.
+	CorruptCount int
+	// MismatchCount is the number of valid snapshots skipped because they
+	// did not match, e.g. were not in the WAL for LoadNewestAvailable.
+	MismatchCount int
+}
+
+// loadMatched returns the newest valid snapshot satisfying matchFn.
+func (s *Snapshotter) loadMatched(ctx context.Context, matchFn func(*snappb.Snapshot) bool) (*loadedSnap, LoadResult, error) {
+	return s.loadMatchedInOrder(ctx, false, matchFn)
+}
+
+// loadMatchedInOrder is like loadMatched, but returns the oldest valid
+// snapshot satisfying matchFn instead if oldestFirst is set.
+func (s *Snapshotter) loadMatchedInOrder(ctx context.Context, oldestFirst bool, matchFn func(*snappb.Snapshot) bool) (*loadedSnap, LoadResult, error) {
+	ls, result, err := s.findMatched(ctx, oldestFirst, matchFn)
 	if err != nil {
-		return err
+		return nil, result, err
 	}
-	defer dir.Close()
-	filenames, err := dir.Readdirnames(-1)
+	m := ls.snap.Metadata
+	s.runHook("OnLoad", s.onLoad, m.GetTerm(), m.GetIndex(), filepath.Join(s.dir, ls.name))
+	return ls, result, nil
+}
+
+func (s *Snapshotter) findMatched(ctx context.Context, oldestFirst bool, matchFn func(*snappb.Snapshot) bool) (*loadedSnap, LoadResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result LoadResult
+	names, err := s.loadnames()
 	if err != nil {
-		return err
+		return nil, result, err
 	}
-	for _, filename := range filenames {
-		if strings.HasSuffix(filename, ".snap.db") {
-			hexIndex := strings.TrimSuffix(filepath.Base(filename), ".snap.db")
-			index, err := strconv.ParseUint(hexIndex, 16, 64)
-			if err != nil {
-				log.Error().Err(err).Str("path", filename).Msg("failed to parse index from snapshot database filename")
+	if oldestFirst {
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+	}
+	var corruptErr error
+	// Candidates are decoded in batches of up to loadConcurrency files,
+	// and checked in order so that the newest match still wins.
+	for start := 0; start < len(names); start += s.loadConcurrency {
+		if err = ctx.Err(); err != nil {
+			return nil, result, err
+		}
+		end := start + s.loadConcurrency
+		if end > len(names) {
+			end = len(names)
+		}
+		for i, r := range s.loadSnaps(names[start:end]) {
+			if r.err != nil {
+				result.CorruptCount++
+				if corruptErr == nil {
+					corruptErr = r.err
+				}
 				continue
 			}
-			if index < snap.Metadata.Index {
-				log.Info().Str("path", filename).Msg("found orphaned .snap.db file; deleting")
-				if rerr := os.Remove(filepath.Join(s.dir, filename)); rerr != nil && !os.IsNotExist(rerr) {
-					log.Error().Err(err).Str("path", filename).Msg("failed to remove orphaned .snap.db file")
-				}
+			if matchFn(r.snap) {
+				result.Matched = true
+				return &loadedSnap{name: names[start+i], snap: r.snap, saved: r.saved}, result, nil
 			}
+			result.MismatchCount++
 		}
 	}
+	s.lg.Warn().Str("path", s.dir).Int("corrupt", result.CorruptCount).Int("mismatch", result.MismatchCount).
+		Msg("no snap file can be loaded")
+	// valid snapshots that do not match tell a stale predicate, e.g. WAL,
+	// from a snapshot directory with nothing to load
+	if result.MismatchCount > 0 {
+		return nil, result, ErrNoMatchingSnapshot
+	}
+	if corruptErr != nil {
+		return nil, result, &noSnapshotError{err: corruptErr}
+	}
+	return nil, result, ErrNoSnapshot
+}
+
+type candidateResult struct {
+	snap  *snappb.Snapshot
+	saved *snappb.SavedSnapshot
+	err   error
+}
+
+// loadSnaps loads the named snap files concurrently, returning
+// the results in the same order as names.
+func (s *Snapshotter) loadSnaps(names []string) []candidateResult {
+	results := make([]candidateResult, len(names))
+	if len(names) == 1 {
+		results[0].snap, results[0].saved, results[0].err = s.loadSnap(names[0])
+		return results
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(names))
+	for i := range names {
+		go func(i int) {
+			defer wg.Done()
+			results[i].snap, results[i].saved, results[i].err = s.loadSnap(names[i])
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *Snapshotter) loadSnap(name string) (*snappb.Snapshot, *snappb.SavedSnapshot, error) {
+	snap, saved, err := s.loadPrimarySnap(name)
+	if err != nil && s.mirror != nil {
+		snap, saved, err = s.loadMirrorSnap(name, err)
+	}
+	if err == nil && s.payloadValidator != nil {
+		err = s.validatePayload(name, snap)
+	}
+	return snap, saved, err
+}
+
+// validatePayload runs the validator set with WithPayloadValidator on snap,
+// loaded from the snap file name, and reports a rejected snapshot with a
+// *CorruptSnapshotError. The file is not renamed to *.broken.
+func (s *Snapshotter) validatePayload(name string, snap *snappb.Snapshot) error {
+	fpath := filepath.Join(s.dir, name)
+	if err := s.payloadValidator(snap); err != nil {
+		s.lg.Warn().Err(err).Str("path", fpath).Msg("snapshot payload failed validation")
+		snapCorruptTotal.WithLabelValues("payload").Inc()
+		return &CorruptSnapshotError{Path: fpath, Err: err}
+	}
 	return nil
 }
+
+// loadPrimarySnap loads the snap file name from the snapshot directory.
+func (s *Snapshotter) loadPrimarySnap(name string) (*snappb.Snapshot, *snappb.SavedSnapshot, error) {
+	fpath := filepath.Join(s.dir, name)
+	snap, saved, err := s.readSnapWithEnvelope(name)
+	// a snap file only found in the mirror is missing from the snapshot directory
+	if err != nil && s.mirror != nil && os.IsNotExist(err) {
+		return snap, saved, err
+	}
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", fpath).Msg("failed to read a snap file")
+		snapCorruptTotal.WithLabelValues(corruptType(err)).Inc()
+		if !s.brokenRename {
+			return snap, saved, err
+		}
+		// an I/O error, e.g. a transient one of a network file system, says
+		// nothing about the content of the file
+		if !isCorruption(err) {
+			s.lg.Info().Str("path", fpath).Msg("snap file failed to read rather than decode; not renaming it")
+			return snap, saved, err
+		}
+		if s.readOnly {
+			s.lg.Info().Str("path", fpath).Msg("snapshotter is read-only; not renaming the broken snap file")
+			return snap, saved, err
+		}
+		broken := s.brokenName(name)
+		brokenPath := filepath.Join(s.dir, broken)
+		if rerr := s.backend.Rename(name, broken); rerr != nil {
+			s.lg.Warn().Err(rerr).AnErr("read-error", err).Str("path", fpath).Str("broken-path", brokenPath).
+				Msg("failed to rename a broken snap file")
+			return snap, saved, &BrokenRenameError{Err: err, RenameErr: rerr}
+		}
+		s.lg.Warn().Err(err).Str("path", fpath).Str("broken-path", brokenPath).Msg("renamed to a broken snap file")
+	}
+	return snap, saved, err
+}
+
+// isCorruption reports whether the snap file that failed to load with err is
+// corrupt, i.e. read but failed to decode or to gunzip, rather than failed to
+// be read. Only corrupt snap files are renamed to *.broken.
+func isCorruption(err error) bool {
+	// a delta whose base failed to load may be intact itself
+	var berr *deltaBaseError
+	if errors.As(err, &berr) {
+		return false
+	}
+	// so may a file this build or configuration cannot decode, e.g. with a
+	// wrong or missing encryption key
+	if errors.Is(err, ErrDecryptionFailed) || errors.Is(err, ErrUnsupportedCodec) || errors.Is(err, ErrUnsupportedChecksum) {
+		return false
+	}
+	var cerr *CorruptSnapshotError
+	return errors.As(err, &cerr) || errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// corruptType classifies the error a snap file failed to load with,
+// for snapCorruptTotal.
+func corruptType(err error) string {
+	var cerr *CorruptSnapshotError
+	switch {
+	case errors.Is(err, ErrEmptyFile), errors.Is(err, ErrTruncatedSnapshot):
+		return "empty"
+	case errors.Is(err, ErrEmptyData):
+		return "empty_data"
+	case errors.Is(err, ErrCRCMismatch), errors.Is(err, ErrEnvelopeCorrupt):
+		return "crc"
+	case errors.As(err, &cerr):
+		return "unmarshal"
+	default:
+		return "other"
+	}
+}
+
+func (s *Snapshotter) readSnap(name string) (*snappb.Snapshot, error) {
+	snap, _, err := s.readSnapWithEnvelope(name)
+	return snap, err
+}
+
+// readSnapWithEnvelope is like readSnap, but also returns the
+// metadata of the envelope of the snap file.
+func (s *Snapshotter) readSnapWithEnvelope(name string) (*snappb.Snapshot, *snappb.SavedSnapshot, error) {
+	snapname := filepath.Join(s.dir, name)
+	b, err := s.readFile(name)
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", snapname).Msg("failed to read a snap file")
+		return nil, nil, err
+	}
+	snap, saved, err := s.decodeSnap(snapname, b)
+	if err != nil {
+		var berr *deltaBaseError
+		if errors.As(err, &berr) {
+			return nil, nil, err
+		}
+		return nil, nil, &CorruptSnapshotError{Path: snapname, Err: err}
+	}
+	return snap, saved, nil
+}
+
+// readFile reads the content of the snap file name, gunzipping it if the name
+// ends in ".gz".
+func (s *Snapshotter) readFile(name string) ([]byte, error) {
+	f, err := s.openFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	// read into a buffer of the size of the file, rather than one grown to up
+	// to twice that, since the decoded snapshot data is a slice of it
+	var buf bytes.Buffer
+	if !strings.HasSuffix(name, gzSuffix) {
+		if fi, err := s.backend.Stat(name); err == nil {
+			buf.Grow(int(fi.Size()) + bytes.MinRead)
+		}
+	}
+	_, err = buf.ReadFrom(f)
+	return buf.Bytes(), err
+}
+
+// openFile opens the snap file name for reading, gunzipping it on the fly if
+// the name ends in ".gz".
+func (s *Snapshotter) openFile(name string) (io.ReadCloser, error) {
+	return s.openFileGzipped(name, strings.HasSuffix(name, gzSuffix))
+}
+
+// openFileGzipped opens the snap file name for reading, gunzipping it on the
+// fly if gzipped is set.
+func (s *Snapshotter) openFileGzipped(name string, gzipped bool) (io.ReadCloser, error) {
+	return openGzipped(s.backend, name, gzipped)
+}
+
+// openGzipped is like openFileGzipped, but opens the file in the backend be.
+func openGzipped(be Backend, name string, gzipped bool) (io.ReadCloser, error) {
+	f, err := be.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if !gzipped {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipReadCloser{Reader: zr, f: f}, nil
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying file.
+type gzipReadCloser struct {
+	*gzip.Reader
+	f io.Closer
+}
+
+func (rc *gzipReadCloser) Close() error {
+	err := rc.Reader.Close()
+	if cerr := rc.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// decodeEnvelope decrypts, if needed, and unmarshals the envelope of the snap
+// file snapname from its content b. The envelope is not verified.
+func (s *Snapshotter) decodeEnvelope(snapname string, b []byte) (*snappb.SavedSnapshot, error) {
+	lg := s.lg
+	if len(b) == 0 {
+		lg.Warn().Str("path", snapname).Msg("failed to read empty snap file")
+		return nil, ErrEmptyFile
+	}
+
+	b, err := stripTrailer(b)
+	if err != nil {
+		lg.Warn().Str("path", snapname).Msg("snap file is corrupt; file checksum mismatch")
+		return nil, err
+	}
+
+	if isEncrypted(b) {
+		if s.aead == nil {
+			lg.Warn().Str("path", snapname).Msg("failed to decrypt snap file; no encryption key configured")
+			return nil, ErrDecryptionFailed
+		}
+		if b, err = decrypt(s.aead, b); err != nil {
+			lg.Warn().Err(err).Str("path", snapname).Msg("failed to decrypt snap file")
+			return nil, err
+		}
+	}
+
+	var serializedSnap snappb.SavedSnapshot
+	if serializedSnap.Data, err = unmarshalAliased(b, &serializedSnap, savedSnapshotDataField); err != nil {
+		lg.Warn().Str("path", snapname).Msg("failed to unmarshal snappb.SavedSnapshot")
+		return nil, err
+	}
+	return &serializedSnap, nil
+}
+
+// verifyEnvelope checks that the envelope of the snap file snapname carries
+// data of the recorded length, and that the data matches its checksum.
+func (s *Snapshotter) verifyEnvelope(snapname string, serializedSnap *snappb.SavedSnapshot) error {
+	lg := s.lg
+	// files written before the length was recorded have a zero length
+	if n := serializedSnap.DataLen; n != 0 && uint64(len(serializedSnap.Data)) != n {
+		lg.Warn().Str("path", snapname).Uint64("expected-len", n).Int("len", len(serializedSnap.Data)).Msg("snap file is truncated")
+		return ErrTruncatedSnapshot
+	}
+	if len(serializedSnap.Data) == 0 {
+		lg.Warn().Str("path", snapname).Msg("failed to read empty snapshot data")
+		return ErrEmptyData
+	}
+	if serializedSnap.Crc == 0 && len(serializedSnap.Checksum) == 0 {
+		if s.allowZeroCRC {
+			lg.Warn().Str("path", snapname).Msg("snap file has no checksum; skipping verification")
+			return nil
+		}
+		lg.Warn().Str("path", snapname).Msg("failed to read empty snapshot data")
+		return ErrEmptyData
+	}
+
+	crc, sum, err := computeChecksum(serializedSnap.Algo, s.readCRCTable(serializedSnap), serializedSnap.Data)
+	if err != nil {
+		lg.Warn().Err(err).Str("path", snapname).Str("algo", serializedSnap.Algo.String()).Msg("failed to compute snapshot checksum")
+		return err
+	}
+	if crc != serializedSnap.Crc || !bytes.Equal(sum, serializedSnap.Checksum) {
+		lg.Warn().Str("path", snapname).Str("algo", serializedSnap.Algo.String()).
+			Uint32("prev-crc", serializedSnap.Crc).Uint32("new-crc", crc).
+			Hex("prev-checksum", serializedSnap.Checksum).Hex("new-checksum", sum).
+			Msg("snap file is corrupt")
+		return ErrCRCMismatch
+	}
+	return nil
+}
+
+// decodeSnap decodes the content b of the snap file snapname, decrypting
+// it if needed and verifying its checksum. Besides the snapshot, it returns
+// the envelope stripped of its data.
+func (s *Snapshotter) decodeSnap(snapname string, b []byte) (*snappb.Snapshot, *snappb.SavedSnapshot, error) {
+	lg := s.lg
+	serializedSnap, err := s.decodeEnvelope(snapname, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = s.verifyEnvelope(snapname, serializedSnap); err != nil {
+		return nil, nil, err
+	}
+
+	data, err := decompress(serializedSnap.Codec, serializedSnap.Data)
+	if err != nil {
+		lg.Warn().Err(err).Str("path", snapname).Str("codec", serializedSnap.Codec.String()).Msg("failed to decompress snapshot data")
+		return nil, nil, err
+	}
+
+	var snap snappb.Snapshot
+	if snap.Data, err = unmarshalAliased(data, &snap, snapshotDataField); err != nil {
+		lg.Warn().Str("path", snapname).Msg("failed to unmarshal snappb.Snapshot")
+		return nil, nil, err
+	}
+	if serializedSnap.BaseIndex != 0 {
+		if err = s.applyDelta(snapname, &snap, serializedSnap); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The data is not needed past this point, don't keep it alive.
+	serializedSnap.Data = nil
+	return &snap, serializedSnap, nil
+}
+
+func (s *Snapshotter) snapnames() ([]string, error) {
+	filenames, err := s.backend.List()
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, &noSnapshotDirError{err: err}
+		}
+		return nil, err
+	}
+	filenames, err = s.cleanupSnapdir(filenames)
+	if err != nil {
+		return nil, err
+	}
+	snaps := s.checkSuffix(filenames)
+	if len(snaps) == 0 {
+		return nil, ErrNoSnapshot
+	}
+	s.sortSnapNames(snaps)
+	return snaps, nil
+}
+
+// sortSnapNames sorts snap filenames newest first, by the term and then the
+// index parsed from their names rather than by the names themselves, so that
+// the order does not depend on how the name codec formats them. A ".snap" file
+// sorts before its ".snap.gz" twin, so that the uncompressed copy is preferred.
+// Names that do not parse sort first, in reverse lexical order, so that they
+// are still tried, and renamed to *.broken if they fail to load, when loading.
+func (s *Snapshotter) sortSnapNames(names []string) {
+	type key struct {
+		term, index uint64
+		ok          bool
+	}
+	keys := make(map[string]key, len(names))
+	for _, name := range names {
+		term, index, err := s.parseSnapName(name)
+		keys[name] = key{term: term, index: index, ok: err == nil}
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		ki, kj := keys[names[i]], keys[names[j]]
+		switch {
+		case ki.ok != kj.ok:
+			return kj.ok
+		case ki.ok && ki.term != kj.term:
+			return ki.term > kj.term
+		case ki.ok && ki.index != kj.index:
+			return ki.index > kj.index
+		}
+		bi, bj := strings.TrimSuffix(names[i], gzSuffix), strings.TrimSuffix(names[j], gzSuffix)
+		if bi != bj {
+			return bi > bj
+		}
+		return len(names[i]) < len(names[j])
+	})
+}
+
+// SnapInfo describes a snapshot file on disk without decoding it.
+type SnapInfo struct {
+	Term    uint64
+	Index   uint64
+	Size    int64
+	ModTime time.Time
+	// CreatedAt is the time the snap file was written at, as recorded in the
+	// file. It is the zero time for files written before it was recorded.
+	CreatedAt time.Time
+	// WriterID is the writer identity configured with WithWriterID when the
+	// snap file was written, if any.
+	WriterID string
+}
+
+// List returns the snapshots available in the snapshot directory, newest first.
+// The term and index are parsed from the file names, files whose names cannot be
+// parsed are skipped. Only the envelope of each file is read to report CreatedAt
+// and WriterID, the snapshots are neither verified nor decoded; files whose
+// envelope cannot be read are listed with these fields left empty.
+func (s *Snapshotter) List() ([]SnapInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.snapnames()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SnapInfo, 0, len(names))
+	for _, name := range names {
+		if _, _, err := s.parseSnapName(name); err != nil {
+			s.lg.Warn().Err(err).Str("path", name).Msg("failed to parse term and index from snap filename; skipping")
+			continue
+		}
+		info, err := s.snapInfo(name, s.readEnvelope(name))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// ListByTerm is like List, but only returns the snapshots of the given term,
+// and reads no file at all: CreatedAt and WriterID are left empty. An empty
+// slice is returned when no snapshot has that term.
+func (s *Snapshotter) ListByTerm(term uint64) ([]SnapInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	infos := []SnapInfo{}
+	names, err := s.snapnames()
+	if err != nil {
+		if err == ErrNoSnapshot {
+			return infos, nil
+		}
+		return nil, err
+	}
+	for _, name := range names {
+		if t, _, err := s.parseSnapName(name); err != nil || t != term {
+			continue
+		}
+		info, err := s.snapInfo(name, nil)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// readEnvelope returns the envelope of the snap file name, or nil if it
+// cannot be read.
+func (s *Snapshotter) readEnvelope(name string) *snappb.SavedSnapshot {
+	b, err := s.readFile(name)
+	if err != nil {
+		return nil
+	}
+	saved, err := s.decodeEnvelope(filepath.Join(s.dir, name), b)
+	if err != nil {
+		return nil
+	}
+	return saved
+}
+
+// snapInfo describes the snap file name, whose envelope saved may be nil.
+func (s *Snapshotter) snapInfo(name string, saved *snappb.SavedSnapshot) (*SnapInfo, error) {
+	term, index, err := s.parseSnapName(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := s.backend.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	info := &SnapInfo{
+		Term:    term,
+		Index:   index,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+	}
+	if saved != nil {
+		if ns := saved.GetCreatedUnixNanos(); ns != 0 {
+			info.CreatedAt = time.Unix(0, ns)
+		}
+		info.WriterID = saved.GetWriterId()
+	}
+	return info, nil
+}
+
+// Exists reports whether the snapshot with the given term and index is present
+// as a non-empty regular file. The file is neither read nor verified, use Load
+// for that. A missing snapshot directory reports false.
+func (s *Snapshotter) Exists(term, index uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fi, err := s.backend.Stat(s.snapName(term, index))
+	if err != nil {
+		return false
+	}
+	return fi.Mode().IsRegular() && fi.Size() > 0
+}
+
+// Stat returns the file info, such as the size, of the snapshot with the given
+// term and index, e.g. to report the progress of a transfer. The file is neither
+// read nor verified. A missing snapshot is reported with an error wrapping
+// ErrNoSnapshot.
+func (s *Snapshotter) Stat(term, index uint64) (os.FileInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fi, err := s.backend.Stat(s.snapName(term, index))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: term %d, index %d", ErrNoSnapshot, term, index)
+		}
+		return nil, err
+	}
+	return fi, nil
+}
+
+// Touch sets the modification time of the snapshot with the given term and
+// index to now, without reading or rewriting it, e.g. to mark a snapshot that is
+// still in use as recent for PruneOlderThan. A missing snapshot is reported
+// with an error wrapping ErrNoSnapshot.
+func (s *Snapshotter) Touch(term, index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ct, ok := s.backend.(chtimer)
+	if !ok {
+		return errors.New("snap: backend does not support changing modification times")
+	}
+	now := s.clock.Now()
+	if err := ct.Chtimes(s.snapName(term, index), now, now); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: term %d, index %d", ErrNoSnapshot, term, index)
+		}
+		return err
+	}
+	return nil
+}
+
+// Latest returns the term and index of the newest snapshot, as told by the
+// names of the snap files: unlike Load, no file is read, so the snapshot may
+// turn out to be corrupt. ErrNoSnapshot is returned if there is no snap file.
+func (s *Snapshotter) Latest() (term, index uint64, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latest()
+}
+
+func (s *Snapshotter) latest() (term, index uint64, err error) {
+	names, err := s.snapnames()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, name := range names {
+		if term, index, err = s.parseSnapName(name); err == nil {
+			return term, index, nil
+		}
+	}
+	return 0, 0, ErrNoSnapshot
+}
+
+// isValidFile reports whether a file which is not a snapshot is expected in
+// the snapshot directory: the built-in valid files, those added with
+// WithValidFiles, and the broken and temporary files this package leaves.
+func (s *Snapshotter) isValidFile(name string) bool {
+	if validFiles[name] || s.validFiles[name] {
+		return true
+	}
+	_, broken := trimBrokenSuffix(name)
+	return broken || strings.HasSuffix(name, tmpSuffix)
+}
+
+// brokenName returns the name the snap file name is renamed to when it fails to
+// load: "<name>.broken", or "<name>.broken.<unix nanos>" with
+// WithTimestampedBroken.
+func (s *Snapshotter) brokenName(name string) string {
+	if !s.brokenTimestamped {
+		return name + brokenSuffix
+	}
+	return name + brokenSuffix + "." + strconv.FormatInt(s.clock.Now().UnixNano(), 10)
+}
+
+// trimBrokenSuffix returns the original name of the broken file name, named by
+// brokenName, and whether name is one.
+func trimBrokenSuffix(name string) (string, bool) {
+	if strings.HasSuffix(name, brokenSuffix) {
+		return strings.TrimSuffix(name, brokenSuffix), true
+	}
+	i := strings.LastIndex(name, brokenSuffix+".")
+	if i < 0 {
+		return "", false
+	}
+	if _, err := strconv.ParseInt(name[i+len(brokenSuffix)+1:], 10, 64); err != nil {
+		return "", false
+	}
+	return name[:i], true
+}
+
+func (s *Snapshotter) checkSuffix(filenames []string) []string {
+	snaps := []string{}
+	for i := range filenames {
+		// Hidden files, such as the partial files of sync tools, are
+		// never snapshots and are ignored silently.
+		if strings.HasPrefix(filenames[i], ".") {
+			continue
+		}
+		if s.isSnapName(filenames[i]) {
+			snaps = append(snaps, filenames[i])
+		} else {
+			// If we find a file which is not a snapshot then check if it's
+			// a vaild file. If not throw out a warning.
+			if !s.isValidFile(filenames[i]) {
+				s.lg.Warn().Str("path", filenames[i]).Msg("found unexpected non-snap file; skipping")
+			}
+		}
+	}
+	return snaps
+}
+
+// cleanupSnapdir removes any files that should not be in the snapshot directory:
+// - db.tmp prefixed files that can be orphaned by defragmentation
+// - .snap.tmp and .snap.tmp.tmp suffixed files orphaned by an interrupted save
+// - files matching the patterns set with WithOrphanPatterns
+func (s *Snapshotter) cleanupSnapdir(filenames []string) (names []string, err error) {
+	names = make([]string, 0, len(filenames))
+	for _, filename := range filenames {
+		typ := s.orphanType(filename)
+		switch {
+		case typ == "":
+			names = append(names, filename)
+		case s.readOnly:
+			s.lg.Info().Str("path", filename).Msg("snapshotter is read-only; skipping cleanup of orphaned file")
+		case typ == "defrag":
+			s.lg.Info().Str("path", filename).Msg("found orphaned defragmentation file; deleting")
+			if rerr := s.backend.Remove(filename); rerr != nil && !os.IsNotExist(rerr) {
+				return names, fmt.Errorf("failed to remove orphaned .snap.db file %s: %v", filename, rerr)
+			}
+			s.orphanRemoved(typ, filename)
+		case typ == "tmp":
+			s.lg.Info().Str("path", filename).Msg("found orphaned temporary snap file; deleting")
+			if rerr := s.backend.Remove(filename); rerr != nil && !os.IsNotExist(rerr) {
+				return names, fmt.Errorf("failed to remove orphaned temporary snap file %s: %v", filename, rerr)
+			}
+			s.orphanRemoved(typ, filename)
+		default:
+			s.lg.Info().Str("path", filename).Msg("found orphaned file matching an orphan pattern; deleting")
+			if rerr := s.backend.Remove(filename); rerr != nil && !os.IsNotExist(rerr) {
+				return names, fmt.Errorf("failed to remove orphaned file %s: %v", filename, rerr)
+			}
+			s.orphanRemoved(typ, filename)
+		}
+	}
+	return names, nil
+}
+
+// orphanType returns the type of orphaned file filename is, for
+// snapOrphanRemovedTotal, or "" if it is not an orphan. Snap files, snapshot
+// database files and valid files are never orphans, whatever the patterns.
+func (s *Snapshotter) orphanType(filename string) string {
+	if s.isSnapName(filename) || strings.HasSuffix(filename, s.dbExt()) || validFiles[filename] || s.validFiles[filename] {
+		return ""
+	}
+	if strings.HasPrefix(filename, "db.tmp") {
+		return "defrag"
+	}
+	if strings.HasSuffix(filename, s.ext+tmpSuffix+tmpSuffix) {
+		// the staging file of a write that may be given up on, which is
+		// written to "<name>.<attempt><ext>.tmp" itself
+		return "tmp"
+	}
+	if strings.HasSuffix(filename, s.ext+tmpSuffix) {
+		// snap files staged by WithSyncBatch are not orphaned
+		if s.pending[strings.TrimSuffix(filename, tmpSuffix)] {
+			return ""
+		}
+		return "tmp"
+	}
+	for _, pattern := range s.orphanPatterns {
+		if strings.ContainsAny(pattern, "*?[\\") {
+			if ok, _ := filepath.Match(pattern, filename); ok {
+				return "custom"
+			}
+		} else if strings.HasPrefix(filename, pattern) {
+			return "custom"
+		}
+	}
+	return ""
+}
+
+// orphanRemoved reports the removal of the orphaned file filename, of the
+// given type, to snapOrphanRemovedTotal and the OnOrphan hook.
+func (s *Snapshotter) orphanRemoved(typ, filename string) {
+	snapOrphanRemovedTotal.WithLabelValues(typ).Inc()
+	s.runOrphanHook(filepath.Join(s.dir, filename))
+}
+
+func (s *Snapshotter) ReleaseSnapDBs(snap *snappb.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filenames, err := s.releasableSnapDBs(snap)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		s.lg.Info().Str("path", filename).Msg("found orphaned .snap.db file; deleting")
+		if rerr := s.backend.Remove(filename); rerr != nil && !os.IsNotExist(rerr) {
+			s.lg.Error().Err(rerr).Str("path", filename).Msg("failed to remove orphaned .snap.db file")
+		}
+	}
+	return nil
+}
+
+// ReleaseSnapDBsDryRun returns the .snap.db files ReleaseSnapDBs would delete
+// for snap, without deleting anything.
+func (s *Snapshotter) ReleaseSnapDBsDryRun(snap *snappb.Snapshot) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.releasableSnapDBs(snap)
+}
+
+// CompactDBs removes every .snap.db file but the one of snap, older and newer
+// ones alike, so that exactly one remains besides those of pinned snapshots,
+// see Pin. Unlike ReleaseSnapDBs, it aborts
+// without removing anything when the .snap.db file of snap is missing, with an
+// error wrapping ErrSnapshotNotFound.
+func (s *Snapshotter) CompactDBs(snap *snappb.Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keep := s.snapDBName(snap.Metadata.Index)
+	fi, err := s.backend.Stat(keep)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: snapshot database file %s", ErrSnapshotNotFound, keep)
+		}
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: snapshot database file %s is not a regular file", ErrSnapshotNotFound, keep)
+	}
+
+	filenames, err := s.backend.List()
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if filename == keep || !strings.HasSuffix(filename, s.dbExt()) {
+			continue
+		}
+		if index, ok := s.parseSnapDBName(filename); ok && s.isIndexPinned(index) {
+			s.lg.Info().Str("path", filename).Msg("snapshot is pinned; not compacting its .snap.db file")
+			continue
+		}
+		s.lg.Info().Str("path", filename).Str("kept-path", keep).Msg("compacting .snap.db files; deleting")
+		if rerr := s.backend.Remove(filename); rerr != nil && !os.IsNotExist(rerr) {
+			return fmt.Errorf("failed to remove .snap.db file %s: %w", filename, rerr)
+		}
+	}
+	return nil
+}
+
+// SnapDBInfo describes a .snap.db file of the snapshot directory.
+type SnapDBInfo struct {
+	// Name is the name of the file, relative to the snapshot directory.
+	Name  string
+	Index uint64
+	Size  int64
+}
+
+// ListSnapDBs returns the .snap.db files in the snapshot directory, by
+// increasing index, e.g. to reason about their retention separately from that
+// of the snapshots. Files whose index cannot be parsed from their name are
+// skipped with a warning, as by ReleaseSnapDBs.
+func (s *Snapshotter) ListSnapDBs() ([]SnapDBInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filenames, err := s.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	infos := []SnapDBInfo{}
+	for _, filename := range filenames {
+		index, ok := s.parseSnapDBName(filename)
+		if !ok {
+			continue
+		}
+		fi, err := s.backend.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, SnapDBInfo{Name: filename, Index: index, Size: fi.Size()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Index < infos[j].Index })
+	return infos, nil
+}
+
+// parseSnapDBName returns the index of the .snap.db file filename, and false if
+// it is not one. Names whose index cannot be parsed are logged.
+func (s *Snapshotter) parseSnapDBName(filename string) (uint64, bool) {
+	if !strings.HasSuffix(filename, s.dbExt()) {
+		return 0, false
+	}
+	hexIndex := strings.TrimSuffix(filepath.Base(filename), s.dbExt())
+	index, err := strconv.ParseUint(hexIndex, 16, 64)
+	if err != nil {
+		s.lg.Error().Err(err).Str("path", filename).Msg("failed to parse index from snapshot database filename")
+		return 0, false
+	}
+	return index, true
+}
+
+// releasableSnapDBs returns the .snap.db files older than snap.
+func (s *Snapshotter) releasableSnapDBs(snap *snappb.Snapshot) ([]string, error) {
+	filenames, err := s.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	var releasable []string
+	for _, filename := range filenames {
+		if index, ok := s.parseSnapDBName(filename); ok && index < snap.Metadata.Index && !s.isIndexPinned(index) {
+			releasable = append(releasable, filename)
+		}
+	}
+	return releasable, nil
+}
+
+// DeleteSnap removes the snapshot with the given term and index, together with
+// the snapshot database file of that index if there is one. It returns an error
+// wrapping ErrSnapshotNotFound if neither file exists.
+func (s *Snapshotter) DeleteSnap(term, index uint64) error {
+	if err := s.deleteSnap(term, index); err != nil {
+		return err
+	}
+	s.runHook("OnDelete", s.onDelete, term, index, filepath.Join(s.dir, s.snapName(term, index)))
+	return nil
+}
+
+func (s *Snapshotter) deleteSnap(term, index uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for _, name := range []string{s.snapName(term, index), s.snapDBName(index)} {
+		err := s.backend.Remove(name)
+		if err == nil {
+			s.lg.Info().Str("path", name).Msg("deleted snap file")
+			s.removeMirror(name)
+			found = true
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: term %d, index %d", ErrSnapshotNotFound, term, index)
+	}
+	return nil
+}
+
+// Prune removes all but the keep newest snapshots, together with their snapshot
+// database files, and returns the names of the removed files. The newest snapshot
+// is always kept, even if keep is zero, so that there is a recovery point left.
+// Snap files whose name does not parse are neither counted nor removed.
+func (s *Snapshotter) Prune(keep int) (removed []string, err error) {
+	removed, err = s.prune(keep)
+	s.runDeleteHooks(removed)
+	return removed, err
+}
+
+// PruneOlderThan removes the snapshots whose snap file was last modified more
+// than age ago, together with their snapshot database files, and returns the
+// names of the removed files. As with Prune, the newest snapshot is always kept,
+// whatever its age, and snap files whose name does not parse are left alone.
+// Touch keeps a snapshot from aging out.
+func (s *Snapshotter) PruneOlderThan(age time.Duration) (removed []string, err error) {
+	removed, err = s.pruneOlderThan(age)
+	s.runDeleteHooks(removed)
+	return removed, err
+}
+
+// runDeleteHooks runs the OnDelete hook for the snap files among removed.
+func (s *Snapshotter) runDeleteHooks(removed []string) {
+	for _, name := range removed {
+		if !s.isSnapName(name) {
+			continue
+		}
+		if term, index, perr := s.parseSnapName(name); perr == nil {
+			s.runHook("OnDelete", s.onDelete, term, index, filepath.Join(s.dir, name))
+		}
+	}
+}
+
+// unlock releases s.mu, then runs the OnDelete hooks of the snapshots evicted
+// while it was held, for operations that may save snapshots.
+func (s *Snapshotter) unlock() {
+	evicted := s.evicted
+	s.evicted = nil
+	s.mu.Unlock()
+	s.runDeleteHooks(evicted)
+}
+
+func (s *Snapshotter) prune(keep int) (removed []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if keep < 1 {
+		keep = 1
+	}
+	names, err := s.snapnames()
+	if err != nil {
+		if err == ErrNoSnapshot {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// snap files whose name does not parse sort first, but are no snapshots
+	// to count, nor to remove
+	names, unparsed := s.splitSnapNames(names)
+	if len(names) <= keep {
+		return nil, nil
+	}
+
+	return s.removeSnaps(names[keep:], append(unparsed, names[:keep]...))
+}
+
+// splitSnapNames splits the sorted snap file names into the ones that parse,
+// in the same order, and the others.
+func (s *Snapshotter) splitSnapNames(names []string) (parsed, unparsed []string) {
+	for _, name := range names {
+		if _, _, err := s.parseSnapName(name); err != nil {
+			unparsed = append(unparsed, name)
+		} else {
+			parsed = append(parsed, name)
+		}
+	}
+	return parsed, unparsed
+}
+
+func (s *Snapshotter) pruneOlderThan(age time.Duration) (removed []string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.snapnames()
+	if err != nil {
+		if err == ErrNoSnapshot {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names, unparsed := s.splitSnapNames(names)
+	if len(names) == 0 {
+		return nil, nil
+	}
+	cutoff := s.clock.Now().Add(-age)
+	kept, old := append(unparsed, names[0]), []string(nil)
+	for _, name := range names[1:] {
+		fi, err := s.backend.Stat(name)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if fi.ModTime().Before(cutoff) {
+			old = append(old, name)
+		} else {
+			kept = append(kept, name)
+		}
+	}
+	return s.removeSnaps(old, kept)
+}
+
+// removeSnaps removes the named snap files, together with their snapshot
+// database files unless one of the kept snapshots shares their index. Pinned
+// snapshots are kept, see Pin, and so are the bases of kept deltas.
+func (s *Snapshotter) removeSnaps(names, kept []string) (removed []string, err error) {
+	var unpinned []string
+	for _, name := range names {
+		if s.isPinned(name) {
+			s.lg.Info().Str("path", name).Msg("snapshot is pinned; not pruning it")
+			kept = append(kept, name)
+		} else {
+			unpinned = append(unpinned, name)
+		}
+	}
+	names = unpinned
+
+	// a delta cannot be loaded without its base
+	if bases := s.deltaBases(kept); len(bases) > 0 {
+		var unneeded []string
+		for _, name := range names {
+			if bases[name] {
+				s.lg.Info().Str("path", name).Msg("snapshot is the base of a kept delta; not pruning it")
+				kept = append(kept, name)
+			} else {
+				unneeded = append(unneeded, name)
+			}
+		}
+		names = unneeded
+	}
+
+	// a database file is still needed as long as one kept snapshot refers to its index
+	keptIndices := make(map[uint64]bool)
+	for _, name := range kept {
+		if _, index, perr := s.parseSnapName(name); perr == nil {
+			keptIndices[index] = true
+		}
+	}
+
+	for _, name := range names {
+		if err = s.backend.Remove(name); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		s.lg.Info().Str("path", name).Msg("pruned snap file")
+		s.removeMirror(name)
+		removed = append(removed, name)
+
+		_, index, perr := s.parseSnapName(name)
+		if perr != nil || keptIndices[index] {
+			continue
+		}
+		dbname := s.snapDBName(index)
+		if err = s.backend.Remove(dbname); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		s.lg.Info().Str("path", dbname).Msg("pruned .snap.db file")
+		removed = append(removed, dbname)
+	}
+	return removed, nil
+}
+
+// evict removes the oldest snapshots, together with their snapshot database
+// files, until the snap and .snap.db files fit in maxBytes. Neither the newest
+// snapshot, the just saved one, saved, pinned ones nor the bases of deltas are
+// evicted. Failures are logged only, the snapshot being saved is durable
+// already.
+func (s *Snapshotter) evict(saved string) {
+	names, err := s.snapnames()
+	if err != nil {
+		s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to list snap files for eviction")
+		return
+	}
+
+	// snap files whose name does not parse are no snapshots to protect, count
+	// or evict
+	names, _ = s.splitSnapNames(names)
+
+	var total int64
+	sizes := make(map[string]int64, len(names))
+	// references counts the snapshots still referring to a database file
+	references := make(map[uint64]int)
+	dbSizes := make(map[uint64]int64)
+	for _, name := range names {
+		if fi, serr := s.backend.Stat(name); serr == nil {
+			sizes[name] = fi.Size()
+			total += fi.Size()
+		}
+		_, index, _ := s.parseSnapName(name)
+		references[index]++
+		if references[index] > 1 {
+			continue
+		}
+		if fi, serr := s.backend.Stat(s.snapDBName(index)); serr == nil {
+			dbSizes[index] = fi.Size()
+			total += fi.Size()
+		}
+	}
+
+	// the bases of deltas are only looked up when something is evicted, as
+	// it takes reading the snap files
+	var bases map[string]bool
+	if total > s.maxBytes {
+		bases = s.deltaBases(names)
+	}
+	for i := len(names) - 1; i > 0 && total > s.maxBytes; i-- {
+		name := names[i]
+		if name == saved || s.isPinned(name) || bases[name] {
+			continue
+		}
+		if err = s.backend.Remove(name); err != nil && !os.IsNotExist(err) {
+			s.lg.Warn().Err(err).Str("path", name).Msg("failed to evict snap file")
+			return
+		}
+		total -= sizes[name]
+		s.removeMirror(name)
+		s.evicted = append(s.evicted, name)
+		s.lg.Info().Str("path", name).Int64("total-bytes", total).Int64("max-bytes", s.maxBytes).Msg("evicted snap file")
+
+		_, index, _ := s.parseSnapName(name)
+		if references[index]--; references[index] > 0 {
+			continue
+		}
+		dbSize, ok := dbSizes[index]
+		if !ok {
+			continue
+		}
+		dbname := s.snapDBName(index)
+		if err = s.backend.Remove(dbname); err != nil && !os.IsNotExist(err) {
+			s.lg.Warn().Err(err).Str("path", dbname).Msg("failed to evict .snap.db file")
+			return
+		}
+		total -= dbSize
+		s.lg.Info().Str("path", dbname).Int64("total-bytes", total).Int64("max-bytes", s.maxBytes).Msg("evicted .snap.db file")
+	}
+	if total > s.maxBytes {
+		s.lg.Warn().Str("path", s.dir).Int64("total-bytes", total).Int64("max-bytes", s.maxBytes).
+			Msg("snapshot directory exceeds the size limit; keeping the newest snapshots")
+	}
+}
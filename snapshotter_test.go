@@ -15,15 +15,24 @@
 package snap
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto" // nolint
+	"github.com/rs/zerolog"
 
 	"github.com/amazingchow/photon-dance-snap/fileutil"
 	"github.com/amazingchow/photon-dance-snap/snappb"
@@ -59,6 +68,67 @@ func TestSaveAndLoad(t *testing.T) {
 	}
 }
 
+func TestSaveAndLoadCompressed(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := NewSnapshotter(dir, WithCompression(ZstdLevel(3)))
+	err = ss.save(testSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var serializedSnap snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &serializedSnap); err != nil {
+		t.Fatal(err)
+	}
+	if serializedSnap.Codec != snappb.Codec_ZSTD {
+		t.Errorf("codec = %v, want %v", serializedSnap.Codec, snappb.Codec_ZSTD)
+	}
+
+	// an uncompressed snapshotter must still be able to read it
+	g, err := NewSnapshotter(dir).Load()
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func TestSaveAndLoadContextCancelled(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := NewSnapshotter(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = ss.SaveSnapContext(ctx, testSnap); err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))) {
+		t.Errorf("expected no snap file to be written by a cancelled save")
+	}
+
+	if err = ss.SaveSnapContext(context.Background(), testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.LoadContext(ctx); err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
 func TestBadCRC(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -76,12 +146,104 @@ func TestBadCRC(t *testing.T) {
 	// fake a crc mismatch
 	crcTable = crc32.MakeTable(crc32.Koopman)
 
-	_, err = readSnap(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
-	if err == nil || err != ErrCRCMismatch {
+	_, err = ss.readSnap(fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	if !errors.Is(err, ErrCRCMismatch) {
 		t.Errorf("err = %v, want %v", err, ErrCRCMismatch)
 	}
 }
 
+func TestChecksumAlgos(t *testing.T) {
+	algos := []snappb.ChecksumAlgo{
+		snappb.ChecksumAlgo_CRC32C,
+		snappb.ChecksumAlgo_XXHASH64,
+		snappb.ChecksumAlgo_SHA256,
+	}
+	for _, algo := range algos {
+		t.Run(algo.String(), func(t *testing.T) {
+			dir := filepath.Join(os.TempDir(), "snapshot")
+			err := os.Mkdir(dir, 0700)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			ss := NewSnapshotter(dir, WithChecksum(algo))
+			err = ss.save(testSnap)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fname := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+			fpath := filepath.Join(dir, fname)
+			g, err := ss.readSnap(fname)
+			if err != nil {
+				t.Fatalf("err = %v, want nil", err)
+			}
+			if !proto.Equal(g, testSnap) {
+				t.Errorf("snap = %#v, want %#v", g, testSnap)
+			}
+
+			// flip a bit of the persisted data to fake a corruption
+			b, err := ioutil.ReadFile(fpath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var serializedSnap snappb.SavedSnapshot
+			if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &serializedSnap); err != nil {
+				t.Fatal(err)
+			}
+			serializedSnap.Data[0] ^= 0xff
+			if b, err = proto.Marshal(&serializedSnap); err != nil {
+				t.Fatal(err)
+			}
+			if err = ioutil.WriteFile(fpath, b, 0666); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = ss.readSnap(fname); !errors.Is(err, ErrCRCMismatch) {
+				t.Errorf("err = %v, want %v", err, ErrCRCMismatch)
+			}
+		})
+	}
+}
+
+func TestWithCRCTable(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithCRCTable(crc32.IEEETable))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &saved); err != nil {
+		t.Fatal(err)
+	}
+	if w := crc32.ChecksumIEEE(saved.Data); saved.Crc != w {
+		t.Errorf("crc = %#x, want %#x", saved.Crc, w)
+	}
+	if saved.CrcPoly != crc32.IEEE {
+		t.Errorf("crc poly = %#x, want %#x", saved.CrcPoly, crc32.IEEE)
+	}
+
+	// readers verify with the recorded polynomial, whatever their own table
+	for _, opts := range [][]SnapshotterOption{nil, {WithCRCTable(crc32.MakeTable(crc32.Koopman))}} {
+		g, err := NewSnapshotter(dir, opts...).Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !proto.Equal(g, testSnap) {
+			t.Errorf("snap = %#v, want %#v", g, testSnap)
+		}
+	}
+}
+
 func TestFailback(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -116,6 +278,117 @@ func TestFailback(t *testing.T) {
 	}
 }
 
+func TestFailbackWithoutBrokenRename(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	large := fmt.Sprintf("%016x-%016x.snap", 0xFFFF, 0xFFFF)
+	err = ioutil.WriteFile(filepath.Join(dir, large), []byte("bad data"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := NewSnapshotter(dir, WithBrokenRename(false))
+	err = ss.save(testSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	if !fileutil.Exist(filepath.Join(dir, large)) {
+		t.Errorf("expected %s to be left in place", large)
+	}
+	if fileutil.Exist(filepath.Join(dir, large) + ".broken") {
+		t.Errorf("expected no broken snapshot to be created")
+	}
+}
+
+func TestWithReadOnly(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	large := fmt.Sprintf("%016x-%016x.snap", 0xFFFF, 0xFFFF)
+	orphans := []string{large, "db.tmp.123", large + tmpSuffix}
+	for _, name := range orphans {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("bad data"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	ss = NewSnapshotter(dir, WithReadOnly(true), WithLogger(zerolog.New(&buf)))
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	for _, name := range orphans {
+		if !fileutil.Exist(filepath.Join(dir, name)) {
+			t.Errorf("expected %s to be left in place", name)
+		}
+	}
+	if fileutil.Exist(filepath.Join(dir, large) + ".broken") {
+		t.Errorf("expected no broken snapshot to be created")
+	}
+	if !strings.Contains(buf.String(), "read-only") {
+		t.Errorf("log output = %q, want a note that cleanup was skipped", buf.String())
+	}
+}
+
+func TestSnapCorruptTotal(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	for index, data := range map[uint64][]byte{2: nil, 3: []byte("bad data")} {
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, index)), data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	types := []string{"empty", "empty_data", "crc", "unmarshal", "other"}
+	before := make(map[string]float64)
+	for _, typ := range types {
+		before[typ] = counterValue(t, snapCorruptTotal.WithLabelValues(typ))
+	}
+	if _, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"empty": 1, "unmarshal": 1}
+	for _, typ := range types {
+		if d := counterValue(t, snapCorruptTotal.WithLabelValues(typ)) - before[typ]; d != want[typ] {
+			t.Errorf("%s: corrupt count = %v, want %v", typ, d, want[typ])
+		}
+	}
+}
+
 func TestSnapNames(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -145,6 +418,85 @@ func TestSnapNames(t *testing.T) {
 	}
 }
 
+func TestList(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a file whose name cannot be parsed is skipped
+	if err = ioutil.WriteFile(filepath.Join(dir, "bad.snap"), []byte("bad"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ss := NewSnapshotter(dir)
+	for _, index := range []uint64{1, 5, 3} {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 2},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := ss.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := []uint64{5, 3, 1}
+	if len(infos) != len(w) {
+		t.Fatalf("len = %d, want %d", len(infos), len(w))
+	}
+	for i, info := range infos {
+		if info.Term != 2 || info.Index != w[i] {
+			t.Errorf("#%d: term/index = %d/%d, want %d/%d", i, info.Term, info.Index, 2, w[i])
+		}
+		if info.Size == 0 || info.ModTime.IsZero() {
+			t.Errorf("#%d: size = %d, modtime = %v, want non-zero", i, info.Size, info.ModTime)
+		}
+	}
+}
+
+func TestListByTerm(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if infos, err := ss.ListByTerm(1); err != nil || infos == nil || len(infos) != 0 {
+		t.Errorf("infos, err = %v, %v, want an empty slice, nil", infos, err)
+	}
+	for _, m := range []*snappb.SnapshotMetadata{{Index: 1, Term: 1}, {Index: 3, Term: 2}, {Index: 4, Term: 2}, {Index: 6, Term: 3}} {
+		if err = ss.save(&snappb.Snapshot{Data: []byte("some snapshot"), Metadata: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := ss.ListByTerm(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var indexes []uint64
+	for _, info := range infos {
+		if info.Term != 2 {
+			t.Errorf("term = %d, want 2", info.Term)
+		}
+		indexes = append(indexes, info.Index)
+	}
+	if w := []uint64{4, 3}; !reflect.DeepEqual(indexes, w) {
+		t.Errorf("indexes = %v, want %v", indexes, w)
+	}
+	if infos, err = ss.ListByTerm(5); err != nil || infos == nil || len(infos) != 0 {
+		t.Errorf("infos, err = %v, %v, want an empty slice, nil", infos, err)
+	}
+}
+
 func TestLoadNewestSnap(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
@@ -214,21 +566,52 @@ func TestLoadNewestSnap(t *testing.T) {
 	}
 }
 
-func TestNoSnapshot(t *testing.T) {
+func TestLoadNewestAvailableOrLatest(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer os.RemoveAll(dir)
+
 	ss := NewSnapshotter(dir)
-	_, err = ss.Load()
-	if err != ErrNoSnapshot {
+	if _, _, err = ss.LoadNewestAvailableOrLatest(nil); err != ErrNoSnapshot {
 		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
 	}
+	newSnap := &snappb.Snapshot{
+		Data:     []byte("newer snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 5, Term: 1},
+	}
+	for _, snap := range []*snappb.Snapshot{testSnap, newSnap} {
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		walSnaps  []snappb.WalSnapshot
+		wsnap     *snappb.Snapshot
+		wfellBack bool
+	}{
+		{[]snappb.WalSnapshot{{Index: 1, Term: 1}}, testSnap, false},
+		{[]snappb.WalSnapshot{{Index: 3, Term: 1}}, newSnap, true},
+		{nil, newSnap, true},
+	}
+	for i, tt := range tests {
+		g, fellBack, err := ss.LoadNewestAvailableOrLatest(tt.walSnaps)
+		if err != nil {
+			t.Fatalf("#%d: err = %v, want nil", i, err)
+		}
+		if !proto.Equal(g, tt.wsnap) {
+			t.Errorf("#%d: snap = %#v, want %#v", i, g, tt.wsnap)
+		}
+		if fellBack != tt.wfellBack {
+			t.Errorf("#%d: fell back = %v, want %v", i, fellBack, tt.wfellBack)
+		}
+	}
 }
 
-func TestEmptySnapshot(t *testing.T) {
+func TestWithStrictWALMatch(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
 	if err != nil {
@@ -236,20 +619,44 @@ func TestEmptySnapshot(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 
-	err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), []byte(""), 0x700)
+	if err = NewSnapshotter(dir).save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	walSnaps := []snappb.WalSnapshot{{Index: 1, Term: 1}, {Index: 3, Term: 1}, {Index: 5, Term: 1}}
+
+	// a newer WAL snapshot without a snap file is not an error
+	ss := NewSnapshotter(dir, WithStrictWALMatch(true))
+	g, err := ss.LoadNewestAvailable(walSnaps)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
 
-	_, err = readSnap(filepath.Join(dir, "1.snap"))
-	if err != ErrEmptySnapshot {
-		t.Errorf("err = %v, want %v", err, ErrEmptySnapshot)
+	corrupt := fmt.Sprintf("%016x-%016x.snap", 1, 3)
+	if err = ioutil.WriteFile(filepath.Join(dir, corrupt), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSnapshotter(dir).LoadNewestAvailable(walSnaps); err != nil {
+		t.Errorf("err = %v, want nil without strict matching", err)
+	}
+	if err = os.Rename(filepath.Join(dir, corrupt+".broken"), filepath.Join(dir, corrupt)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		// the corrupt file is renamed to *.broken by the first load
+		_, err = ss.LoadNewestAvailable(walSnaps)
+		if !errors.Is(err, ErrNewerSnapshotCorrupt) {
+			t.Fatalf("#%d: err = %v, want %v", i, err, ErrNewerSnapshotCorrupt)
+		}
+		if !strings.Contains(err.Error(), corrupt) {
+			t.Errorf("#%d: err = %v, want it to name %s", i, err, corrupt)
+		}
 	}
 }
 
-// TestAllSnapshotBroken ensures snapshotter returns
-// ErrNoSnapshot if all the snapshots are broken.
-func TestAllSnapshotBroken(t *testing.T) {
+func TestLoadNewestAvailableConcurrently(t *testing.T) {
 	dir := filepath.Join(os.TempDir(), "snapshot")
 	err := os.Mkdir(dir, 0700)
 	if err != nil {
@@ -257,15 +664,242 @@ func TestAllSnapshotBroken(t *testing.T) {
 	}
 	defer os.RemoveAll(dir)
 
-	err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), []byte("bad"), 0x700)
+	ss := NewSnapshotter(dir, WithLoadConcurrency(3))
+	var walSnaps []snappb.WalSnapshot
+	for index := uint64(1); index <= 10; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte(fmt.Sprintf("snapshot %d", index)),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		if index <= 4 {
+			walSnaps = append(walSnaps, snappb.WalSnapshot{Index: index, Term: 1})
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 11)), []byte("bad data"), 0666)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	ss := NewSnapshotter(dir)
-	_, err = ss.Load()
-	if err != ErrNoSnapshot {
-		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	g, err := ss.LoadNewestAvailable(walSnaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 4 {
+		t.Errorf("index = %d, want 4", g.Metadata.Index)
+	}
+}
+
+func TestLoadNewestAvailableWithResult(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithBrokenRename(false))
+	for index := uint64(1); index <= 3; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 4)), []byte("bad data"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, result, err := ss.LoadNewestAvailableWithResult([]snappb.WalSnapshot{{Index: 1, Term: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 1 {
+		t.Errorf("index = %d, want 1", g.Metadata.Index)
+	}
+	if w := (LoadResult{Matched: true, CorruptCount: 1, MismatchCount: 2}); result != w {
+		t.Errorf("result = %+v, want %+v", result, w)
+	}
+
+	_, result, err = ss.LoadNewestAvailableWithResult([]snappb.WalSnapshot{{Index: 5, Term: 1}})
+	if err != ErrNoMatchingSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoMatchingSnapshot)
+	}
+	// callers checking for ErrNoSnapshot keep working
+	if !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want it to match %v", err, ErrNoSnapshot)
+	}
+	if w := (LoadResult{CorruptCount: 1, MismatchCount: 3}); result != w {
+		t.Errorf("result = %+v, want %+v", result, w)
+	}
+}
+
+func TestNoSnapshot(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := NewSnapshotter(dir)
+	_, err = ss.Load()
+	if err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+}
+
+func TestNoSnapshotDir(t *testing.T) {
+	ss := NewSnapshotter(filepath.Join(os.TempDir(), "snapshot-missing"))
+	_, err := ss.Load()
+	if !errors.Is(err, ErrNoSnapshotDir) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshotDir)
+	}
+	if errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want not %v", err, ErrNoSnapshot)
+	}
+}
+
+func TestEmptySnapshot(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), []byte(""), 0x700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewSnapshotter(dir).readSnap("1.snap")
+	if !errors.Is(err, ErrEmptySnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrEmptySnapshot)
+	}
+	if !errors.Is(err, ErrEmptyFile) || errors.Is(err, ErrEmptyData) {
+		t.Errorf("err = %v, want %v", err, ErrEmptyFile)
+	}
+}
+
+func TestEmptySnapshotData(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := proto.Marshal(&snappb.SavedSnapshot{Crc: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewSnapshotter(dir).readSnap("1.snap")
+	if !errors.Is(err, ErrEmptySnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrEmptySnapshot)
+	}
+	if !errors.Is(err, ErrEmptyData) || errors.Is(err, ErrEmptyFile) {
+		t.Errorf("err = %v, want %v", err, ErrEmptyData)
+	}
+}
+
+func TestTruncatedSnapshot(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	fpath := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &saved); err != nil {
+		t.Fatal(err)
+	}
+	saved.Data = saved.Data[:len(saved.Data)-1]
+	if b, err = proto.Marshal(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fpath, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ss.readSnap(filepath.Base(fpath)); !errors.Is(err, ErrTruncatedSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrTruncatedSnapshot)
+	}
+}
+
+func TestAllowZeroCRC(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := proto.Marshal(testSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(&snappb.SavedSnapshot{Data: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewSnapshotter(dir).readSnap("1.snap"); !errors.Is(err, ErrEmptySnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrEmptySnapshot)
+	}
+	g, err := NewSnapshotter(dir, WithAllowZeroCRC(true)).readSnap("1.snap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+// TestAllSnapshotBroken ensures snapshotter returns
+// ErrNoSnapshot if all the snapshots are broken.
+func TestAllSnapshotBroken(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), []byte("bad"), 0x700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := NewSnapshotter(dir)
+	_, err = ss.Load()
+	if !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	var cerr *CorruptSnapshotError
+	if !errors.As(err, &cerr) || cerr.Path != filepath.Join(dir, "1.snap") {
+		t.Errorf("err = %v, want a *CorruptSnapshotError for %s", err, filepath.Join(dir, "1.snap"))
 	}
 }
 
@@ -287,6 +921,21 @@ func TestReleaseSnapDBs(t *testing.T) {
 
 	ss := NewSnapshotter(dir)
 
+	toRelease, err := ss.ReleaseSnapDBsDryRun(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{Index: 300}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(toRelease)
+	if w := []string{fmt.Sprintf("%016x.snap.db", 100), fmt.Sprintf("%016x.snap.db", 200)}; !reflect.DeepEqual(toRelease, w) {
+		t.Errorf("dry run = %v, want %v", toRelease, w)
+	}
+	for _, index := range snapIndices {
+		filename := filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index))
+		if !fileutil.Exist(filename) {
+			t.Errorf("expected %s (index: %d) to be retained by a dry run, but it no longer exists", filename, index)
+		}
+	}
+
 	if err := ss.ReleaseSnapDBs(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{Index: 300}}); err != nil {
 		t.Fatal(err)
 	}
@@ -307,3 +956,1177 @@ func TestReleaseSnapDBs(t *testing.T) {
 		}
 	}
 }
+
+func TestListSnapDBs(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	dbs, err := ss.ListSnapDBs()
+	if err != nil || dbs == nil || len(dbs) != 0 {
+		t.Errorf("dbs = %v, %v, want an empty list", dbs, err)
+	}
+
+	files := map[string]string{
+		ss.snapDBName(0x200): "db 200",
+		ss.snapDBName(0x10):  "db 10",
+		"malformed.snap.db":  "malformed",
+		"db":                 "not a snapshot database",
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if dbs, err = ss.ListSnapDBs(); err != nil {
+		t.Fatal(err)
+	}
+	w := []SnapDBInfo{
+		{Name: ss.snapDBName(0x10), Index: 0x10, Size: int64(len("db 10"))},
+		{Name: ss.snapDBName(0x200), Index: 0x200, Size: int64(len("db 200"))},
+	}
+	if !reflect.DeepEqual(dbs, w) {
+		t.Errorf("dbs = %+v, want %+v", dbs, w)
+	}
+}
+
+func TestCompactDBs(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapIndices := []uint64{100, 200, 400}
+	for _, index := range snapIndices {
+		filename := filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index))
+		if err := ioutil.WriteFile(filename, []byte("snap file\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ss := NewSnapshotter(dir)
+	// nothing is removed while the database file of the snapshot is missing
+	if err = ss.CompactDBs(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{Index: 300}}); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotNotFound)
+	}
+	for _, index := range snapIndices {
+		filename := filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index))
+		if !fileutil.Exist(filename) {
+			t.Errorf("expected %s (index: %d) to be retained, but it no longer exists", filename, index)
+		}
+	}
+
+	if err = ss.CompactDBs(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{Index: 200}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, index := range snapIndices {
+		filename := filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index))
+		if fileutil.Exist(filename) != (index == 200) {
+			t.Errorf("exist(%s) = %v, want %v", filename, !(index == 200), index == 200)
+		}
+	}
+}
+
+func TestDeleteSnap(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	dbname := filepath.Join(dir, fmt.Sprintf("%016x.snap.db", 1))
+	if err = ioutil.WriteFile(dbname, []byte("snap file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ss.DeleteSnap(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))) {
+		t.Errorf("expected snap file to be deleted, but it still exists")
+	}
+	if fileutil.Exist(dbname) {
+		t.Errorf("expected %s to be deleted, but it still exists", dbname)
+	}
+
+	if err = ss.DeleteSnap(1, 1); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotNotFound)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	for index := uint64(1); index <= 4; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index)), []byte("snap file\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := ss.Prune(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := []string{
+		fmt.Sprintf("%016x-%016x.snap", 1, 2), fmt.Sprintf("%016x.snap.db", 2),
+		fmt.Sprintf("%016x-%016x.snap", 1, 1), fmt.Sprintf("%016x.snap.db", 1),
+	}
+	if !reflect.DeepEqual(removed, w) {
+		t.Errorf("removed = %v, want %v", removed, w)
+	}
+
+	// the newest snapshot survives even when asked to keep none, and a snap
+	// file whose name does not parse neither counts as it nor is removed
+	if err = ioutil.WriteFile(filepath.Join(dir, "garbage.snap"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ss.Prune(0); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ss.snapnames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wn := []string{"garbage.snap", fmt.Sprintf("%016x-%016x.snap", 1, 4)}; !reflect.DeepEqual(names, wn) {
+		t.Errorf("names = %v, want %v", names, wn)
+	}
+}
+
+func TestPruneOlderThan(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	old := time.Now().Add(-2 * time.Hour)
+	for index := uint64(1); index <= 4; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index)), []byte("snap file\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// all but the snapshot at index 3 are old, the newest one included
+		if index != 3 {
+			if err = os.Chtimes(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, index)), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// an old snap file whose name does not parse is not taken for the newest
+	// snapshot
+	garbage := filepath.Join(dir, "garbage.snap")
+	if err = ioutil.WriteFile(garbage, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(garbage, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := ss.PruneOlderThan(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := []string{
+		fmt.Sprintf("%016x-%016x.snap", 1, 2), fmt.Sprintf("%016x.snap.db", 2),
+		fmt.Sprintf("%016x-%016x.snap", 1, 1), fmt.Sprintf("%016x.snap.db", 1),
+	}
+	if !reflect.DeepEqual(removed, w) {
+		t.Errorf("removed = %v, want %v", removed, w)
+	}
+	names, err := ss.snapnames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wn := []string{"garbage.snap", fmt.Sprintf("%016x-%016x.snap", 1, 4), fmt.Sprintf("%016x-%016x.snap", 1, 3)}; !reflect.DeepEqual(names, wn) {
+		t.Errorf("names = %v, want %v", names, wn)
+	}
+}
+
+func TestWithMaxBytes(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	saveWithDB := func(ss *Snapshotter, index uint64) {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", index)), []byte("snap file\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saveWithDB(NewSnapshotter(dir), 1)
+	fi, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// room for two snapshots and their database files
+	var ss *Snapshotter
+	var deleted []uint64
+	onDelete := func(term, index uint64, path string) {
+		// the hook runs once the lock is released, and may use ss
+		if _, herr := ss.Load(); herr != nil {
+			t.Error(herr)
+		}
+		deleted = append(deleted, index)
+	}
+	ss = NewSnapshotter(dir, WithMaxBytes(2*(fi.Size()+int64(len("snap file\n")))), WithOnDelete(onDelete))
+	saveWithDB(ss, 2)
+	saveWithDB(ss, 3)
+	if w := []uint64{1}; !reflect.DeepEqual(deleted, w) {
+		t.Errorf("deleted = %v, want %v", deleted, w)
+	}
+
+	names, err := ss.snapnames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 3), fmt.Sprintf("%016x-%016x.snap", 1, 2)}; !reflect.DeepEqual(names, w) {
+		t.Errorf("names = %v, want %v", names, w)
+	}
+	if fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x.snap.db", 1))) {
+		t.Errorf("expected the .snap.db file of the evicted snapshot to be removed")
+	}
+
+	// the newest snapshot is kept even if it exceeds the limit on its own, and
+	// a snap file whose name does not parse does not take its place
+	if err = ioutil.WriteFile(filepath.Join(dir, "garbage.snap"), []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ss = NewSnapshotter(dir, WithMaxBytes(1))
+	saveWithDB(ss, 4)
+	if names, err = ss.snapnames(); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{"garbage.snap", fmt.Sprintf("%016x-%016x.snap", 1, 4)}; !reflect.DeepEqual(names, w) {
+		t.Errorf("names = %v, want %v", names, w)
+	}
+}
+
+func TestLoadGzipped(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	gzipSnap := func(term, index uint64, data string) *snappb.Snapshot {
+		snap := &snappb.Snapshot{
+			Data:     []byte(data),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: term},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		fpath := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", term, index))
+		b, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(b); err != nil {
+			t.Fatal(err)
+		}
+		if err = zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(fpath+".gz", buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Remove(fpath); err != nil {
+			t.Fatal(err)
+		}
+		return snap
+	}
+
+	gzipped := gzipSnap(1, 2, "gzipped snapshot")
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, gzipped) {
+		t.Errorf("snap = %#v, want %#v", g, gzipped)
+	}
+
+	// an uncompressed twin is preferred over the gzipped file
+	gzipSnap(1, 3, "gzipped snapshot")
+	plain := &snappb.Snapshot{
+		Data:     []byte("plain snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1},
+	}
+	if err = ss.save(plain); err != nil {
+		t.Fatal(err)
+	}
+	if g, err = ss.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, plain) {
+		t.Errorf("snap = %#v, want %#v", g, plain)
+	}
+	names, err := ss.snapnames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := []string{
+		fmt.Sprintf("%016x-%016x.snap", 1, 3),
+		fmt.Sprintf("%016x-%016x.snap.gz", 1, 3),
+		fmt.Sprintf("%016x-%016x.snap.gz", 1, 2),
+	}
+	if !reflect.DeepEqual(names, w) {
+		t.Errorf("names = %v, want %v", names, w)
+	}
+}
+
+func TestExists(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	if NewSnapshotter(dir).Exists(1, 1) {
+		t.Errorf("exists = true for a missing directory, want false")
+	}
+
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2)), []byte(""), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if !ss.Exists(1, 1) {
+		t.Errorf("exists = false, want true")
+	}
+	if ss.Exists(1, 2) {
+		t.Errorf("exists = true for an empty file, want false")
+	}
+	if ss.Exists(1, 3) {
+		t.Errorf("exists = true for a missing file, want false")
+	}
+}
+
+func TestStat(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := ss.Stat(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != w.Size() || !fi.ModTime().Equal(w.ModTime()) {
+		t.Errorf("size/modtime = %d/%v, want %d/%v", fi.Size(), fi.ModTime(), w.Size(), w.ModTime())
+	}
+	if _, err = ss.Stat(1, 2); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+}
+
+func TestWithFileMode(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a pre-existing file keeps its permissions when overwritten in place
+	fpath := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	if err = ioutil.WriteFile(fpath, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ss := NewSnapshotter(dir, WithFileMode(0600))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want %v", fi.Mode().Perm(), os.FileMode(0600))
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "1.snap"), []byte("bad"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	ss := NewSnapshotter(dir, WithLogger(zerolog.New(&buf).With().Str("group-id", "g1").Logger()))
+	if _, err = ss.Load(); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if !strings.Contains(buf.String(), `"group-id":"g1"`) {
+		t.Errorf("log output = %q, want it to carry the group-id field", buf.String())
+	}
+}
+
+func TestWithValidFiles(t *testing.T) {
+	var buf bytes.Buffer
+	ss := NewSnapshotter("", WithLogger(zerolog.New(&buf)), WithValidFiles("metadata.json", "LOCK"))
+	snaps := ss.checkSuffix([]string{
+		"db",
+		"metadata.json",
+		"LOCK",
+		fmt.Sprintf("%016x-%016x.snap.broken", 1, 1),
+		fmt.Sprintf("%016x-%016x.snap"+tmpSuffix, 1, 2),
+		"other.txt",
+		".dropbox.cache",
+		fmt.Sprintf(".%016x-%016x.snap", 1, 4),
+		fmt.Sprintf("%016x-%016x.snap", 1, 3),
+	})
+	if w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 3)}; !reflect.DeepEqual(snaps, w) {
+		t.Errorf("snaps = %v, want %v", snaps, w)
+	}
+	if n := strings.Count(buf.String(), "found unexpected non-snap file"); n != 1 || !strings.Contains(buf.String(), "other.txt") {
+		t.Errorf("log output = %q, want a single warning for other.txt", buf.String())
+	}
+}
+
+func TestWithSync(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	ss := NewSnapshotter(dir, WithSync(false), WithLogger(zerolog.New(&buf)), WithClock(&stepClock{step: time.Second}))
+	if !strings.Contains(buf.String(), "fsync of snap files is disabled") {
+		t.Errorf("log output = %q, want a warning that fsync is disabled", buf.String())
+	}
+
+	before := histogramSum(t, snapFsyncSec)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if d := histogramSum(t, snapFsyncSec) - before; d != 0 {
+		t.Errorf("observed fsync duration = %vs, want none", d)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func TestWithOverwrite(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	// overwriting is allowed by default
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	ss = NewSnapshotter(dir, WithOverwrite(false))
+	changed := &snappb.Snapshot{Data: []byte("changed"), Metadata: testSnap.Metadata}
+	if err = ss.save(changed); !errors.Is(err, ErrSnapshotExists) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotExists)
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+}
+
+func TestWithMaxSnapshotBytes(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	size := int64(proto.Size(testSnap))
+	ss := NewSnapshotter(dir, WithMaxSnapshotBytes(size-1))
+	if err = ss.save(testSnap); !errors.Is(err, ErrSnapshotTooLarge) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotTooLarge)
+	}
+	if names, _ := ioutil.ReadDir(dir); len(names) != 0 {
+		t.Errorf("len(files) = %d, want 0", len(names))
+	}
+
+	ss = NewSnapshotter(dir, WithMaxSnapshotBytes(size))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveSnapN(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithCompression(ZstdLevel(3)))
+	n, err := ss.SaveSnapN(testSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != fi.Size() {
+		t.Errorf("n = %d, want %d", n, fi.Size())
+	}
+	if n, err = ss.SaveSnapN(&snappb.Snapshot{Metadata: &snappb.SnapshotMetadata{}}); n != 0 || err != nil {
+		t.Errorf("n, err = %d, %v, want 0, nil", n, err)
+	}
+}
+
+func TestEnsureDir(t *testing.T) {
+	root := filepath.Join(os.TempDir(), "snapshot")
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "member", "snap")
+	ss := NewSnapshotter(dir, WithDirMode(0750))
+	if err := ss.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0750 {
+		t.Errorf("mode = %v, want a directory with %v", fi.Mode(), os.FileMode(0750))
+	}
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	// calling it again on an existing directory is fine
+	if err = ss.EnsureDir(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().Add(time.Hour).Truncate(time.Second)
+	ss := NewSnapshotter(dir, WithClock(&stepClock{now: now}))
+	if err = ss.Touch(1, 1); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.Touch(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := ss.Stat(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(now) {
+		t.Errorf("mtime = %v, want %v", fi.ModTime(), now)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if _, _, err = ss.Latest(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	for _, m := range []*snappb.SnapshotMetadata{{Index: 1, Term: 1}, {Index: 7, Term: 2}} {
+		if err = ss.save(&snappb.Snapshot{Data: []byte("some snapshot"), Metadata: m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	term, index, err := ss.Latest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if term != 2 || index != 7 {
+		t.Errorf("term, index = %d, %d, want 2, 7", term, index)
+	}
+}
+
+func TestWithMonotonicIndex(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithMonotonicIndex(true))
+	tests := []struct {
+		term, index uint64
+		werr        error
+	}{
+		{1, 5, nil},
+		{2, 5, ErrNonMonotonicIndex},
+		{1, 3, ErrNonMonotonicIndex},
+		{1, 6, nil},
+	}
+	for i, tt := range tests {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: tt.index, Term: tt.term},
+		}
+		if err = ss.save(snap); !errors.Is(err, tt.werr) {
+			t.Errorf("#%d: err = %v, want %v", i, err, tt.werr)
+		}
+	}
+}
+
+func TestLoadOldest(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if _, err = ss.LoadOldest(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	// the oldest snap file is corrupt
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	snaps := []*snappb.Snapshot{
+		{Data: []byte("a"), Metadata: &snappb.SnapshotMetadata{Index: 2, Term: 1}},
+		{Data: []byte("b"), Metadata: &snappb.SnapshotMetadata{Index: 3, Term: 1}},
+	}
+	for _, snap := range snaps {
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := ss.LoadOldest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, snaps[0]) {
+		t.Errorf("snap = %#v, want %#v", g, snaps[0])
+	}
+}
+
+func TestLoadByIndex(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	snaps := []*snappb.Snapshot{
+		{Data: []byte("a"), Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1}},
+		{Data: []byte("b"), Metadata: &snappb.SnapshotMetadata{Index: 5, Term: 1}},
+		{Data: []byte("c"), Metadata: &snappb.SnapshotMetadata{Index: 5, Term: 2}},
+		{Data: []byte("d"), Metadata: &snappb.SnapshotMetadata{Index: 9, Term: 2}},
+	}
+	for _, snap := range snaps {
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := ss.LoadByIndex(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, snaps[0]) {
+		t.Errorf("snap = %#v, want %#v", g, snaps[0])
+	}
+	if g, err = ss.LoadByIndex(5); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, snaps[2]) {
+		t.Errorf("snap = %#v, want %#v", g, snaps[2])
+	}
+	if _, err = ss.LoadByIndex(7); err != ErrNoMatchingSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoMatchingSnapshot)
+	}
+}
+
+func TestWithPayloadValidator(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	errGarbage := errors.New("garbage state")
+	ss := NewSnapshotter(dir, WithPayloadValidator(func(snap *snappb.Snapshot) error {
+		if string(snap.Data) != "some snapshot" {
+			return errGarbage
+		}
+		return nil
+	}))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	garbage := &snappb.Snapshot{
+		Data:     []byte("garbage"),
+		Metadata: &snappb.SnapshotMetadata{Index: 2, Term: 1},
+	}
+	if err = ss.save(garbage); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	// the rejected snap file is left in place
+	if !fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2))) {
+		t.Errorf("expected the rejected snap file to be left in place")
+	}
+	if err = ss.DeleteSnap(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	var cerr *CorruptSnapshotError
+	if _, err = ss.Load(); !errors.Is(err, ErrNoSnapshot) || !errors.Is(err, errGarbage) || !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want %v wrapping a *CorruptSnapshotError wrapping %v", err, ErrNoSnapshot, errGarbage)
+	}
+}
+
+func TestConcurrentSaveLoadPrune(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 3)
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for index := uint64(2); index <= 20; index++ {
+			snap := &snappb.Snapshot{
+				Data:     []byte("some snapshot"),
+				Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+			}
+			if err := ss.SaveSnap(snap); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := ss.Load(); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := ss.Prune(2); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Error(err)
+	}
+}
+
+func TestCleanupTmpSnapFiles(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if fileutil.Exist(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap.tmp", 1, 1))) {
+		t.Errorf("expected the temporary file to be renamed by save")
+	}
+
+	// fake an interrupted save, and an interrupted save that could be given
+	// up on, staged by the backend under its own temporary name
+	tmps := []string{
+		filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap.tmp", 1, 2)),
+		filepath.Join(dir, fmt.Sprintf("%016x-%016x.1.snap.tmp.tmp", 1, 3)),
+	}
+	for _, tmp := range tmps {
+		if err = ioutil.WriteFile(tmp, []byte("partial"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	for _, tmp := range tmps {
+		if fileutil.Exist(tmp) {
+			t.Errorf("expected %s to be removed", tmp)
+		}
+	}
+}
+
+func TestWithOrphanPatterns(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// "0*" matches the snap files and the .snap.db file, which are never removed
+	ss := NewSnapshotter(dir, WithOrphanPatterns("*.partial", "tool-tmp", "0*"))
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	orphans := []string{"upload.partial", "tool-tmp-1"}
+	kept := []string{fmt.Sprintf("%016x.snap.db", 1), "db", "other"}
+	for _, name := range append(orphans, kept...) {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte("some data"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	for _, name := range orphans {
+		if fileutil.Exist(filepath.Join(dir, name)) {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	for _, name := range kept {
+		if !fileutil.Exist(filepath.Join(dir, name)) {
+			t.Errorf("expected %s to be left in place", name)
+		}
+	}
+}
+
+func TestLoadRecent(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	var snaps []*snappb.Snapshot
+	for index := uint64(1); index <= 3; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte(fmt.Sprintf("snapshot %d", index)),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, snap)
+	}
+	// the newest one is corrupt
+	err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 4)), []byte("bad data"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.LoadRecent(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g) != 2 || !proto.Equal(g[0], snaps[2]) || !proto.Equal(g[1], snaps[1]) {
+		t.Errorf("snaps = %v, want %v", g, []*snappb.Snapshot{snaps[2], snaps[1]})
+	}
+
+	// fewer valid snapshots than asked for is not an error
+	if g, err = ss.LoadRecent(10); err != nil {
+		t.Fatal(err)
+	}
+	if len(g) != 3 {
+		t.Errorf("len = %d, want 3", len(g))
+	}
+}
+
+func TestLoadRange(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if snaps, err := ss.LoadRange(1, 10); err != nil || len(snaps) != 0 {
+		t.Errorf("snaps/err = %v/%v, want an empty slice and nil", snaps, err)
+	}
+	for index := uint64(1); index <= 5; index++ {
+		snap := &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: index, Term: 1},
+		}
+		if err = ss.save(snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snaps, err := ss.LoadRange(2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g []uint64
+	for _, snap := range snaps {
+		g = append(g, snap.Metadata.Index)
+	}
+	if w := []uint64{4, 3, 2}; !reflect.DeepEqual(g, w) {
+		t.Errorf("indexes = %v, want %v", g, w)
+	}
+	if snaps, err = ss.LoadRange(6, 10); err != nil || snaps == nil || len(snaps) != 0 {
+		t.Errorf("snaps/err = %v/%v, want an empty slice and nil", snaps, err)
+	}
+}
+
+func TestLoadOrInit(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	g, err := ss.LoadOrInit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 0 || g.Metadata.Term != 0 || len(g.Data) != 0 {
+		t.Errorf("snap = %#v, want an empty snapshot", g)
+	}
+
+	// a corrupt snapshot is not mistaken for a new node
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// neither once the corrupt snap file is renamed to *.broken
+	for i := 0; i < 2; i++ {
+		if _, err = ss.LoadOrInit(); !errors.Is(err, ErrNoSnapshot) || err == ErrNoSnapshot {
+			t.Errorf("#%d: err = %v, want an error wrapping the corrupt snapshot", i, err)
+		}
+	}
+
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if g, err = ss.LoadOrInit(); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// a snapshot directory that does not exist yet is a new node as well
+	if g, err = NewSnapshotter(filepath.Join(dir, "missing")).LoadOrInit(); err != nil {
+		t.Fatal(err)
+	}
+	if g.Metadata.Index != 0 || g.Metadata.Term != 0 || len(g.Data) != 0 {
+		t.Errorf("snap = %#v, want an empty snapshot", g)
+	}
+}
+
+func TestLoadWithPath(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	g, fpath, err := ss.LoadWithPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	if w := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)); fpath != w {
+		t.Errorf("path = %s, want %s", fpath, w)
+	}
+}
+
+func TestSnapInfoWriterMetadata(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a snap file written before the creation time and writer were recorded
+	d, err := proto.Marshal(testSnap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := proto.Marshal(&snappb.SavedSnapshot{Crc: crc32.Checksum(d, crcTable), Data: d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)), b, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1600000000, 0)
+	ss := NewSnapshotter(dir, WithWriterID("node-1"), WithClock(&stepClock{now: start, step: time.Second}))
+	newSnap := &snappb.Snapshot{
+		Data:     []byte("some snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 5, Term: 1},
+	}
+	if err = ss.save(newSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := ss.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 {
+		t.Fatalf("len = %d, want 2", len(infos))
+	}
+	wCreated := start.Add(time.Second)
+	if !infos[0].CreatedAt.Equal(wCreated) || infos[0].WriterID != "node-1" {
+		t.Errorf("created/writer = %v/%q, want %v/%q", infos[0].CreatedAt, infos[0].WriterID, wCreated, "node-1")
+	}
+	if !infos[1].CreatedAt.IsZero() || infos[1].WriterID != "" {
+		t.Errorf("created/writer = %v/%q, want zero values", infos[1].CreatedAt, infos[1].WriterID)
+	}
+
+	g, info, err := ss.LoadWithInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, newSnap) {
+		t.Errorf("snap = %#v, want %#v", g, newSnap)
+	}
+	if info.Index != 5 || !info.CreatedAt.Equal(wCreated) || info.WriterID != "node-1" {
+		t.Errorf("info = %+v, want index 5 created at %v by %q", info, wCreated, "node-1")
+	}
+}
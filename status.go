@@ -0,0 +1,88 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"strings"
+)
+
+// Status summarizes the snapshot directory, see Snapshotter.Status.
+type Status struct {
+	// Count is the number of snap files whose checksum is valid.
+	Count int
+	// Broken is the number of *.broken files plus the number of snap files
+	// whose checksum is not valid.
+	Broken int
+	// NewestTerm and NewestIndex identify the newest snapshot whose checksum is
+	// valid, OldestTerm and OldestIndex the oldest one. They are zero when
+	// Count is.
+	NewestTerm, NewestIndex uint64
+	OldestTerm, OldestIndex uint64
+	// TotalBytes is the size of the snap files, broken ones included, and of
+	// the .snap.db files.
+	TotalBytes int64
+}
+
+// Status summarizes the snapshot directory from a single listing of it, e.g.
+// for an admin endpoint, instead of combining List, Latest and the size of the
+// directory. The snap files are checked as by VerifyCRC, without being
+// decoded. Like Verify, it leaves the directory untouched.
+func (s *Snapshotter) Status() (Status, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var st Status
+	filenames, err := s.backend.List()
+	if err != nil {
+		return st, err
+	}
+	var snaps []string
+	for _, name := range filenames {
+		isSnap := s.isSnapName(name)
+		_, isBroken := trimBrokenSuffix(name)
+		if !isSnap && !isBroken && !strings.HasSuffix(name, s.dbExt()) {
+			continue
+		}
+		fi, err := s.backend.Stat(name)
+		if err != nil {
+			return st, err
+		}
+		st.TotalBytes += fi.Size()
+		switch {
+		case isSnap:
+			snaps = append(snaps, name)
+		case isBroken:
+			st.Broken++
+		}
+	}
+
+	s.sortSnapNames(snaps)
+	for _, name := range snaps {
+		if err := s.verifyCRC(name); err != nil {
+			st.Broken++
+			continue
+		}
+		st.Count++
+		term, index, err := s.parseSnapName(name)
+		if err != nil {
+			continue
+		}
+		if st.NewestIndex == 0 {
+			st.NewestTerm, st.NewestIndex = term, index
+		}
+		st.OldestTerm, st.OldestIndex = term, index
+	}
+	return st, nil
+}
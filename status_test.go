@@ -0,0 +1,81 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestStatus(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if st, err := ss.Status(); err != nil || st != (Status{}) {
+		t.Errorf("status = %+v, %v, want an empty status", st, err)
+	}
+
+	var total int64
+	for _, index := range []uint64{2, 3} {
+		snap := proto.Clone(testSnap).(*snappb.Snapshot)
+		snap.Metadata.Index = index
+		n, err := ss.SaveSnapN(snap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += n
+	}
+	files := map[string]string{
+		fmt.Sprintf("%016x-%016x.snap", 1, 4):        "bad data",
+		fmt.Sprintf("%016x-%016x.snap.broken", 1, 1): "broken",
+		ss.snapDBName(3): "db",
+		"db":             "ignored",
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	total += int64(len("bad data") + len("broken") + len("db"))
+
+	st, err := ss.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := Status{
+		Count:       2,
+		Broken:      2,
+		NewestTerm:  1,
+		NewestIndex: 3,
+		OldestTerm:  1,
+		OldestIndex: 2,
+		TotalBytes:  total,
+	}
+	if st != w {
+		t.Errorf("status = %+v, want %+v", st, w)
+	}
+}
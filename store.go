@@ -0,0 +1,149 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// SnapshotStore is the subset of the Snapshotter methods needed to persist and
+// retrieve snapshots, so that callers can be handed a Snapshotter backed by
+// either the filesystem or memory.
+type SnapshotStore interface {
+	SaveSnap(snapshot *snappb.Snapshot) error
+	Load() (*snappb.Snapshot, error)
+	List() ([]SnapInfo, error)
+	DeleteSnap(term, index uint64) error
+}
+
+var _ SnapshotStore = (*Snapshotter)(nil)
+
+// NewMemSnapshotter returns a Snapshotter keeping its snap files in memory,
+// e.g. for tests. Since only the Backend differs, snap files are encoded,
+// verified and ordered exactly as they are on the filesystem.
+func NewMemSnapshotter(opts ...SnapshotterOption) *Snapshotter {
+	return NewSnapshotter("", append([]SnapshotterOption{WithBackend(NewMemBackend())}, opts...)...)
+}
+
+// MemBackend is a Backend keeping the snap files in memory, keyed on
+// their file names. It is safe for concurrent use.
+type MemBackend struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	name    string
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMemBackend returns an empty MemBackend.
+func NewMemBackend() *MemBackend {
+	return &MemBackend{files: make(map[string]*memFile)}
+}
+
+func (mb *MemBackend) Open(name string) (io.ReadCloser, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	f, ok := mb.files[name]
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return ioutil.NopCloser(bytes.NewReader(f.data)), nil
+}
+
+// Write stores a copy of data, which becomes visible atomically.
+func (mb *MemBackend) Write(name string, data []byte, perm os.FileMode) error {
+	f := &memFile{name: name, data: append([]byte(nil), data...), mode: perm, modTime: time.Now()}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.files[name] = f
+	return nil
+}
+
+func (mb *MemBackend) List() ([]string, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	names := make([]string, 0, len(mb.files))
+	for name := range mb.files {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (mb *MemBackend) Stat(name string) (os.FileInfo, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	f, ok := mb.files[name]
+	if !ok {
+		return nil, notExist("stat", name)
+	}
+	return f, nil
+}
+
+func (mb *MemBackend) Remove(name string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if _, ok := mb.files[name]; !ok {
+		return notExist("remove", name)
+	}
+	delete(mb.files, name)
+	return nil
+}
+
+func (mb *MemBackend) Rename(oldname, newname string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	f, ok := mb.files[oldname]
+	if !ok {
+		return notExist("rename", oldname)
+	}
+	delete(mb.files, oldname)
+	mb.files[newname] = &memFile{name: newname, data: f.data, mode: f.mode, modTime: f.modTime}
+	return nil
+}
+
+// Chtimes sets the modification time of the named object to mtime.
+func (mb *MemBackend) Chtimes(name string, atime, mtime time.Time) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	f, ok := mb.files[name]
+	if !ok {
+		return notExist("chtimes", name)
+	}
+	mb.files[name] = &memFile{name: name, data: f.data, mode: f.mode, modTime: mtime}
+	return nil
+}
+
+func notExist(op, name string) error {
+	return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+}
+
+// memFile implements os.FileInfo.
+func (f *memFile) Name() string       { return f.name }
+func (f *memFile) Size() int64        { return int64(len(f.data)) }
+func (f *memFile) Mode() os.FileMode  { return f.mode }
+func (f *memFile) ModTime() time.Time { return f.modTime }
+func (f *memFile) IsDir() bool        { return false }
+func (f *memFile) Sys() interface{}   { return nil }
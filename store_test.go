@@ -0,0 +1,82 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestMemSnapshotter(t *testing.T) {
+	var ss SnapshotStore = NewMemSnapshotter()
+	if _, err := ss.Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+
+	snaps := make([]*snappb.Snapshot, 3)
+	for i := range snaps {
+		snaps[i] = &snappb.Snapshot{
+			Data:     []byte("some snapshot"),
+			Metadata: &snappb.SnapshotMetadata{Index: uint64(i + 1), Term: 1},
+		}
+		if err := ss.SaveSnap(snaps[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, snaps[2]) {
+		t.Errorf("snap = %#v, want %#v", g, snaps[2])
+	}
+
+	if err = ss.DeleteSnap(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ss.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Index != 2 || infos[1].Index != 1 {
+		t.Errorf("infos = %+v, want indexes 2 and 1", infos)
+	}
+}
+
+func TestMemSnapshotterBroken(t *testing.T) {
+	mb := NewMemBackend()
+	ss := NewMemSnapshotter(WithBackend(mb))
+	if err := ss.SaveSnap(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	name := "0000000000000001-0000000000000002.snap"
+	if err := mb.Write(name, []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := ss.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+	if _, err = mb.Stat(name + ".broken"); err != nil {
+		t.Errorf("err = %v, want the corrupt file renamed to .broken", err)
+	}
+}
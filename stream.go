@@ -0,0 +1,212 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+// WriteTo copies the raw bytes of the newest valid snap file to w without
+// buffering the whole file in memory, and returns the number of bytes written.
+// The bytes are the serialized snappb.SavedSnapshot, gunzipped if the file is a
+// .snap.gz, so the receiving side can verify them with ReadFrom. Snap files are
+// verified as Load does before being copied, which reads them once more, and
+// skipped if they fail to load.
+func (s *Snapshotter) WriteTo(w io.Writer) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.snapnames()
+	if err != nil {
+		return 0, err
+	}
+	for _, name := range names {
+		fpath := filepath.Join(s.dir, name)
+		if fi, err := s.backend.Stat(name); err != nil || fi.Size() == 0 {
+			s.lg.Warn().Str("path", fpath).Msg("skipped empty snap file")
+			continue
+		}
+		snap, err := s.readSnap(name)
+		if err == nil && s.payloadValidator != nil {
+			err = s.validatePayload(name, snap)
+		}
+		if err != nil {
+			s.lg.Warn().Err(err).Str("path", fpath).Msg("skipped invalid snap file")
+			continue
+		}
+		f, err := s.openFile(name)
+		if err != nil {
+			s.lg.Warn().Err(err).Str("path", fpath).Msg("failed to open a snap file")
+			continue
+		}
+		n, err := io.Copy(w, f)
+		f.Close()
+		return n, err
+	}
+	return 0, ErrNoSnapshot
+}
+
+// OpenNewest opens the newest non-empty snap file for reading, e.g. to stream
+// it elsewhere without buffering it in memory, and describes it. As with
+// WriteTo, the bytes read are the serialized snappb.SavedSnapshot, gunzipped if
+// the file is a .snap.gz. The file is not verified, and CreatedAt and WriterID
+// are left empty, since both would require reading it. The caller must close
+// the returned reader. It stays readable even if the snapshot gets removed.
+func (s *Snapshotter) OpenNewest() (io.ReadCloser, *SnapInfo, error) {
+	return s.openNewest(false)
+}
+
+// OpenNewestVerified is like OpenNewest, but verifies the checksum of the snap
+// file first, skipping to the next one if it is corrupt, and fills in CreatedAt
+// and WriterID. Verifying reads the whole file once more.
+func (s *Snapshotter) OpenNewestVerified() (io.ReadCloser, *SnapInfo, error) {
+	return s.openNewest(true)
+}
+
+func (s *Snapshotter) openNewest(verify bool) (io.ReadCloser, *SnapInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names, err := s.snapnames()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, name := range names {
+		fpath := filepath.Join(s.dir, name)
+		if fi, err := s.backend.Stat(name); err != nil || fi.Size() == 0 {
+			s.lg.Warn().Str("path", fpath).Msg("skipped empty snap file")
+			continue
+		}
+		var saved *snappb.SavedSnapshot
+		if verify {
+			b, err := s.readFile(name)
+			if err == nil {
+				if saved, err = s.decodeEnvelope(fpath, b); err == nil {
+					err = s.verifyEnvelope(fpath, saved)
+				}
+			}
+			if err != nil {
+				s.lg.Warn().Err(err).Str("path", fpath).Msg("skipped corrupt snap file")
+				continue
+			}
+		}
+		info, err := s.snapInfo(name, saved)
+		if err != nil {
+			s.lg.Warn().Err(err).Str("path", fpath).Msg("failed to describe a snap file")
+			continue
+		}
+		f, err := s.openFile(name)
+		if err != nil {
+			s.lg.Warn().Err(err).Str("path", fpath).Msg("failed to open a snap file")
+			continue
+		}
+		return f, info, nil
+	}
+	return nil, nil, ErrNoSnapshot
+}
+
+// ReadFrom reads a snap file written by WriteTo from r, verifies its checksum,
+// and persists it through the regular save path. It returns the number of bytes
+// read from r.
+func (s *Snapshotter) ReadFrom(r io.Reader) (int64, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return int64(len(b)), err
+	}
+	snap, _, err := s.decodeSnap("<stream>", b)
+	if err == nil {
+		err = checkStreamedSnap(snap)
+	}
+	if err != nil {
+		return int64(len(b)), err
+	}
+	return int64(len(b)), s.save(snap)
+}
+
+// checkStreamedSnap rejects a streamed snapshot that SaveSnap would not save,
+// for lack of metadata or of an index, rather than skipping it silently.
+func checkStreamedSnap(snap *snappb.Snapshot) error {
+	if snap.Metadata == nil || snap.Metadata.Index == 0 {
+		return errors.New("snap: streamed snapshot has no metadata or a zero index")
+	}
+	return nil
+}
+
+// ExportSnapGz gzips the raw bytes of the snap file of the snapshot with the
+// given term and index to w, e.g. for an ad-hoc backup of a single snapshot,
+// without buffering the whole file in memory. As with WriteTo, the gunzipped
+// bytes are the serialized snappb.SavedSnapshot; a .snap.gz file is copied as
+// it is. The file is not verified, ImportSnapGz does it. A missing snapshot is
+// reported with an error wrapping ErrSnapshotNotFound.
+func (s *Snapshotter) ExportSnapGz(term, index uint64, w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	name := s.snapName(term, index)
+	for _, candidate := range []string{name, name + gzSuffix} {
+		// the file is opened as it is, gzipped files need no recompression
+		f, err := s.backend.Open(candidate)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if strings.HasSuffix(candidate, gzSuffix) {
+			_, err = io.Copy(w, f)
+			return err
+		}
+		zw := gzip.NewWriter(w)
+		zw.Name = candidate
+		if _, err = io.Copy(zw, f); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	return fmt.Errorf("%w: term %d, index %d", ErrSnapshotNotFound, term, index)
+}
+
+// ImportSnapGz reads a snap file gzipped by ExportSnapGz from r, verifies its
+// checksum, and persists it through the regular save path. Unlike the export,
+// the import holds the snapshot in memory, since it is verified before it is
+// saved. A corrupt snap file is reported with a *CorruptSnapshotError.
+func (s *Snapshotter) ImportSnapGz(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return &CorruptSnapshotError{Path: "<stream>", Err: err}
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return &CorruptSnapshotError{Path: "<stream>", Err: err}
+	}
+	snap, _, err := s.decodeSnap("<stream>", b)
+	if err == nil {
+		err = checkStreamedSnap(snap)
+	}
+	if err != nil {
+		return &CorruptSnapshotError{Path: "<stream>", Err: err}
+	}
+	return s.save(snap)
+}
@@ -0,0 +1,208 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestWriteToReadFrom(t *testing.T) {
+	srcDir := filepath.Join(os.TempDir(), "snapshot-src")
+	dstDir := filepath.Join(os.TempDir(), "snapshot-dst")
+	for _, dir := range []string{srcDir, dstDir} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	src := NewSnapshotter(srcDir)
+	if err := src.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	// a newer corrupt snap file is skipped
+	if err := ioutil.WriteFile(filepath.Join(srcDir, fmt.Sprintf("%016x-%016x.snap", 1, 2)), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	n, err := src.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("n = %d, want %d", n, buf.Len())
+	}
+
+	dst := NewSnapshotter(dstDir)
+	if _, err = dst.ReadFrom(&buf); err != nil {
+		t.Fatal(err)
+	}
+	g, err := dst.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	if _, err = dst.ReadFrom(bytes.NewReader([]byte("bad data"))); err == nil {
+		t.Errorf("err = nil, want non-nil")
+	}
+	// a valid snap file of a snapshot without metadata is rejected
+	bufs, _, err := src.encodeSnap("", &snappb.Snapshot{Data: []byte("some snapshot")}, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dst.ReadFrom(bytes.NewReader(concatBuffers(bufs))); err == nil {
+		t.Errorf("err = nil, want an error for a snapshot without metadata")
+	}
+}
+
+func TestOpenNewest(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir, WithWriterID("node-1"))
+	if _, _, err = ss.OpenNewest(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2)), []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		open    func() (io.ReadCloser, *SnapInfo, error)
+		windex  uint64
+		wwriter string
+	}{
+		{ss.OpenNewest, 2, ""},
+		{ss.OpenNewestVerified, 1, "node-1"},
+	}
+	for i, tt := range tests {
+		rc, info, err := tt.open()
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Index != tt.windex || info.WriterID != tt.wwriter {
+			t.Errorf("#%d: index, writer = %d, %q, want %d, %q", i, info.Index, info.WriterID, tt.windex, tt.wwriter)
+		}
+		w, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, tt.windex)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, w) || int64(len(b)) != info.Size {
+			t.Errorf("#%d: read %d bytes, want the %d bytes of the snap file", i, len(b), len(w))
+		}
+	}
+}
+
+func TestExportImportSnapGz(t *testing.T) {
+	srcDir := filepath.Join(os.TempDir(), "snapshot-src")
+	dstDir := filepath.Join(os.TempDir(), "snapshot-dst")
+	for _, dir := range []string{srcDir, dstDir} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	src := NewSnapshotter(srcDir)
+	if err := src.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := src.ExportSnapGz(1, 1, &buf); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	dst := NewSnapshotter(dstDir)
+	if err := dst.ImportSnapGz(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	g, err := dst.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	// a .snap.gz file is exported as it is
+	name := filepath.Join(srcDir, fmt.Sprintf("%016x-%016x.snap", 1, 1))
+	if err = ioutil.WriteFile(name+".gz", b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err = src.ExportSnapGz(1, 1, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Errorf("exported %d bytes, want the %d bytes of the .snap.gz file", buf.Len(), len(b))
+	}
+
+	if err = src.ExportSnapGz(1, 2, &buf); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("err = %v, want %v", err, ErrSnapshotNotFound)
+	}
+	var cerr *CorruptSnapshotError
+	if err = dst.ImportSnapGz(bytes.NewReader(b[:len(b)/2])); !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want a *CorruptSnapshotError", err)
+	}
+
+	// a valid snap file of a snapshot without metadata is rejected
+	bufs, _, err := src.encodeSnap("", &snappb.Snapshot{Data: []byte("some snapshot")}, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	zw := gzip.NewWriter(&buf)
+	if _, err = zw.Write(concatBuffers(bufs)); err != nil {
+		t.Fatal(err)
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = dst.ImportSnapGz(&buf); !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want a *CorruptSnapshotError", err)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Sub returns a Snapshotter for the subdirectory name of the snapshot
+// directory, e.g. for one of many raft groups sharing a root, configured with
+// the same options as s: logger, clock, compression, checksum, encryption, name
+// codec and extension, crc table, file and directory modes, hooks and so on. A
+// mirror set with WithMirror is rooted at the subdirectory name of the mirror
+// directory. Files being written are kept in the subdirectory, even if s keeps
+// them in a temporary directory. The subdirectories are created if create is
+// set, as with EnsureDir. Sub fails if s persists its snap files in a Backend
+// set with WithBackend, which has no subdirectories.
+func (s *Snapshotter) Sub(name string, create bool) (*Snapshotter, error) {
+	if _, ok := s.backend.(*FileBackend); !ok {
+		return nil, fmt.Errorf("snap: cannot derive a snapshotter for %q from a custom backend", name)
+	}
+	sub := &Snapshotter{settings: s.settings}
+	sub.dir = filepath.Join(s.dir, name)
+	sub.backend = NewFileBackend(sub.dir)
+	if s.mirror != nil {
+		sub.mirrorDir = filepath.Join(s.mirrorDir, name)
+		sub.mirror = NewFileBackend(sub.mirrorDir)
+	}
+	if create {
+		if err := sub.EnsureDir(); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
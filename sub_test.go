@@ -0,0 +1,77 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestSub(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mirrorDir := filepath.Join(os.TempDir(), "snapshot-mirror")
+	defer os.RemoveAll(mirrorDir)
+
+	ss := NewSnapshotter(dir, WithCompression(ZstdLevel(3)), WithChecksum(snappb.ChecksumAlgo_SHA256), WithMirror(mirrorDir))
+	sub, err := ss.Sub("group-1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sub.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	for _, d := range []string{dir, mirrorDir} {
+		if _, err = os.Stat(filepath.Join(d, "group-1", name)); err != nil {
+			t.Errorf("err = %v, want nil", err)
+		}
+	}
+	if _, err = ss.Load(); err != ErrNoSnapshot {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+	_, saved, err := sub.readSnapWithEnvelope(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Codec != snappb.Codec_ZSTD || saved.Algo != snappb.ChecksumAlgo_SHA256 {
+		t.Errorf("codec, algo = %v, %v, want %v, %v", saved.Codec, saved.Algo, snappb.Codec_ZSTD, snappb.ChecksumAlgo_SHA256)
+	}
+	if sub, err = ss.Sub("group-1", false); err != nil {
+		t.Fatal(err)
+	}
+	g, err := sub.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, testSnap) {
+		t.Errorf("snap = %#v, want %#v", g, testSnap)
+	}
+
+	if _, err = NewSnapshotter("", WithBackend(NewMemBackend())).Sub("group-1", true); err == nil {
+		t.Errorf("err = nil, want an error for a custom backend")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ExportTar writes the snap files, the snapshot database files and the other
+// valid files of the snapshot directory to w as a tar archive, e.g. for an
+// off-box backup. Broken and temporary files are left out.
+func (s *Snapshotter) ExportTar(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	filenames, err := s.backend.List()
+	if err != nil {
+		return err
+	}
+	sort.Strings(filenames)
+	tw := tar.NewWriter(w)
+	for _, name := range filenames {
+		if !s.isExportable(name) {
+			continue
+		}
+		if err = s.exportFile(tw, name); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func (s *Snapshotter) exportFile(tw *tar.Writer, name string) error {
+	fi, err := s.backend.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := s.backend.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     fi.Size(),
+		Mode:     int64(fi.Mode().Perm()),
+		ModTime:  fi.ModTime(),
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// isExportable reports whether the file name belongs in an export.
+func (s *Snapshotter) isExportable(name string) bool {
+	return s.isSnapName(name) || strings.HasSuffix(name, s.dbExt()) || validFiles[name] || s.validFiles[name]
+}
+
+// ImportTar extracts a tar archive written by ExportTar into the snapshot
+// directory, overwriting files of the same name. Every snap file is verified
+// before it is written, and the import stops at the first corrupt one, which
+// is reported as a *CorruptSnapshotError. Entries that do not belong in a
+// snapshot directory are skipped.
+func (s *Snapshotter) ImportTar(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || name != filepath.Base(name) || !s.isExportable(name) {
+			s.lg.Warn().Str("path", name).Msg("skipped unexpected archive entry")
+			continue
+		}
+		b, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		if s.isSnapName(name) {
+			if err = s.verifyImported(name, b); err != nil {
+				return err
+			}
+		}
+		// a failed write leaves the file it would replace, if any, in place
+		if err = s.writeFile(name, b); err != nil {
+			return fmt.Errorf("failed to import %s: %w", name, err)
+		}
+		s.lg.Info().Str("path", filepath.Join(s.dir, name)).Msg("imported file")
+	}
+	if ds, ok := s.backend.(dirSyncer); ok {
+		if err := ds.SyncDir(); err != nil {
+			s.lg.Warn().Err(err).Str("path", s.dir).Msg("failed to fsync the snapshot directory")
+		}
+	}
+	return nil
+}
+
+// verifyImported decodes the content b of the snap file name.
+func (s *Snapshotter) verifyImported(name string, b []byte) error {
+	snapname := filepath.Join(s.dir, name)
+	if strings.HasSuffix(name, gzSuffix) {
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return &CorruptSnapshotError{Path: snapname, Err: err}
+		}
+		if b, err = ioutil.ReadAll(zr); err != nil {
+			return &CorruptSnapshotError{Path: snapname, Err: err}
+		}
+	}
+	if _, _, err := s.decodeSnap(snapname, b); err != nil {
+		return &CorruptSnapshotError{Path: snapname, Err: err}
+	}
+	return nil
+}
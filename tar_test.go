@@ -0,0 +1,153 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/amazingchow/photon-dance-snap/snappb"
+	"github.com/golang/protobuf/proto" // nolint
+)
+
+func TestExportImportTar(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		fmt.Sprintf("%016x.snap.db", 1):                 "snap file\n",
+		fmt.Sprintf("%016x-%016x.snap.broken", 1, 2):    "broken",
+		fmt.Sprintf("%016x-%016x.snap"+tmpSuffix, 1, 3): "partial",
+	}
+	for name, data := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err = ss.ExportTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	importDir := filepath.Join(os.TempDir(), "snapshot-import")
+	if err = os.Mkdir(importDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(importDir)
+	is := NewSnapshotter(importDir)
+	if err = is.ImportTar(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ioutil.ReadDir(importDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var g []string
+	for _, fi := range names {
+		g = append(g, fi.Name())
+	}
+	w := []string{fmt.Sprintf("%016x-%016x.snap", 1, 1), fmt.Sprintf("%016x.snap.db", 1)}
+	sort.Strings(w)
+	if !reflect.DeepEqual(g, w) {
+		t.Errorf("imported = %v, want %v", g, w)
+	}
+	snap, err := is.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(snap, testSnap) {
+		t.Errorf("snap = %#v, want %#v", snap, testSnap)
+	}
+}
+
+func TestImportTarCorrupt(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Size: 8, Mode: 0666}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tw.Write([]byte("bad data")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var cerr *CorruptSnapshotError
+	if err = NewSnapshotter(dir).ImportTar(&buf); !errors.As(err, &cerr) {
+		t.Errorf("err = %v, want a *CorruptSnapshotError", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want the corrupt snap file not to be written", err)
+	}
+}
+
+func TestImportTarWriteFailureKeepsFile(t *testing.T) {
+	ss := NewSnapshotter("", WithBackend(NewMemBackend()))
+	if err := ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := ss.ExportTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	older := &snappb.Snapshot{
+		Data:     []byte("older snapshot"),
+		Metadata: &snappb.SnapshotMetadata{Index: 1, Term: 1},
+	}
+	mb := NewMemBackend()
+	if err := NewSnapshotter("", WithBackend(mb)).save(older); err != nil {
+		t.Fatal(err)
+	}
+	errWrite := errors.New("write failed")
+	is := NewSnapshotter("", WithBackend(&flakyBackend{MemBackend: mb, failures: 1, err: errWrite}))
+	if err := is.ImportTar(&buf); !errors.Is(err, errWrite) {
+		t.Errorf("err = %v, want %v", err, errWrite)
+	}
+	g, err := is.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(g, older) {
+		t.Errorf("snap = %#v, want %#v", g, older)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2015 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snap
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto" // nolint
+
+	"github.com/amazingchow/photon-dance-snap/fileutil"
+	"github.com/amazingchow/photon-dance-snap/snappb"
+)
+
+func TestVerify(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 2))
+	if err = ioutil.WriteFile(bad, []byte("bad data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := ss.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("len = %d, want 2", len(results))
+	}
+	if results[0].Path != bad || results[0].OK || results[0].Err == nil {
+		t.Errorf("results[0] = %+v, want a failed result for %s", results[0], bad)
+	}
+	if !results[1].OK || results[1].Err != nil {
+		t.Errorf("results[1] = %+v, want an ok result", results[1])
+	}
+	if !fileutil.Exist(bad) {
+		t.Errorf("expected %s to be left in place", bad)
+	}
+}
+
+func TestVerifyCRC(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	name := fmt.Sprintf("%016x-%016x.snap", 1, 1)
+	if err = ss.VerifyCRC(name); err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+
+	fpath := filepath.Join(dir, name)
+	b, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a bit in the snapshot data
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &saved); err != nil {
+		t.Fatal(err)
+	}
+	saved.Data[0] ^= 1
+	if b, err = proto.Marshal(&saved); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(fpath, b, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err = ss.VerifyCRC(name); !errors.Is(err, ErrCRCMismatch) {
+		t.Errorf("err = %v, want %v", err, ErrCRCMismatch)
+	}
+	if !fileutil.Exist(fpath) {
+		t.Errorf("expected %s to be left in place", fpath)
+	}
+
+	if err = ss.VerifyCRC("missing.snap"); !os.IsNotExist(err) {
+		t.Errorf("err = %v, want a not exist error", err)
+	}
+}
+
+func TestCRC(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "snapshot")
+	err := os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := NewSnapshotter(dir)
+	if err = ss.save(testSnap); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%016x-%016x.snap", 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved snappb.SavedSnapshot
+	if err = proto.Unmarshal(b[:len(b)-envelopeTrailerLen], &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	crc, err := ss.CRC(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crc != saved.Crc || crc == 0 {
+		t.Errorf("crc = %d, want %d", crc, saved.Crc)
+	}
+	if _, err = ss.CRC(1, 2); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("err = %v, want %v", err, ErrNoSnapshot)
+	}
+}